@@ -0,0 +1,314 @@
+/**
+ * Package arcauthtest runs an in-process fake of the arc-auth-server for tests, no Docker needed
+ *
+ * The fake serves the /api/v1/auth endpoint like the real server: it requires the peer's BasicAuth, answers 200 with
+ * the identity JSON of known tokens and 204 for unknown ones.  Behaviors can be scripted to reproduce latency,
+ * 5xx bursts, 204s and dropped connections deterministically.
+ */
+package arcauthtest
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "sync"
+    "time"
+)
+
+// AdmiralTokenHeader is the header the tokens are sent in, the same as arcauth.AdmiralTokenHeader
+const AdmiralTokenHeader = "X-Admiral-Token"
+
+// AuthPath is the path of the endpoint the fake serves
+const AuthPath = "/api/v1/auth"
+
+// DemoUser and DemoPass are the peer credentials a Server built by NewServer accepts
+const (
+    DemoUser = "demo-app"
+    DemoPass = "demo-pass"
+)
+
+/**
+ * DefaultFixtures returns two synthetic tokens shaped like the identities this client decodes (user, roles and
+ * organization), they are not copied from a real arc-auth-server.  FakeDemoToken belongs to vaughant, the user the
+ * boot2docker integration tests expect for it.
+ */
+func DefaultFixtures() map[string]string {
+    return map[string]string{
+        "FakeDemoToken": `{"user":{"id":7,"username":"vaughant","email":"vaughant@example.com","first_name":"Tim","last_name":"Vaughan"},"roles":["admin","editor"],"organization":{"id":3,"name":"WPMedia"}}`,
+        "FakeReaderToken": `{"user":{"id":8,"username":"reader","email":"reader@example.com","first_name":"Rea","last_name":"Der"},"roles":["reader"],"organization":{"id":3,"name":"WPMedia"}}`,
+    }
+}
+
+/**
+ * Server is a running fake arc-auth-server, pass its URL to arcauth.New with User() and Pass()
+ */
+type Server struct {
+    *httptest.Server
+
+    mutex    sync.Mutex
+    user     string
+    pass     string
+    fixtures map[string]string
+    script   []Behavior
+    requests int
+}
+
+/**
+ * NewServer starts a fake accepting DemoUser/DemoPass and loaded with DefaultFixtures, Close it when done
+ */
+func NewServer() *Server {
+    return NewServerWithPeer(DemoUser, DemoPass)
+}
+
+/**
+ * NewServerWithPeer starts a fake accepting the given peer credentials and loaded with DefaultFixtures
+ */
+func NewServerWithPeer(user, pass string) *Server {
+    server := &Server{user: user, pass: pass, fixtures: DefaultFixtures()}
+    server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+    return server
+}
+
+/**
+ * SetPeer changes the peer credentials the fake accepts, e.g. to simulate a password rotation
+ */
+func (this *Server) SetPeer(user, pass string) {
+    this.mutex.Lock()
+    this.user = user
+    this.pass = pass
+    this.mutex.Unlock()
+}
+
+/**
+ * User returns the peer user the fake currently accepts
+ */
+func (this *Server) User() string {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return this.user
+}
+
+/**
+ * Pass returns the peer password the fake currently accepts
+ */
+func (this *Server) Pass() string {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return this.pass
+}
+
+/**
+ * AddToken makes token valid, identity is marshalled to JSON and returned as is by the fake
+ */
+func (this *Server) AddToken(token string, identity interface{}) error {
+    raw, err := json.Marshal(identity)
+    if err != nil {
+        return err
+    }
+    this.AddTokenJSON(token, string(raw))
+    return nil
+}
+
+/**
+ * AddTokenJSON makes token valid with the raw identity JSON
+ */
+func (this *Server) AddTokenJSON(token, raw string) {
+    this.mutex.Lock()
+    this.fixtures[token] = raw
+    this.mutex.Unlock()
+}
+
+/**
+ * RemoveToken makes token invalid, e.g. to simulate a logout
+ */
+func (this *Server) RemoveToken(token string) {
+    this.mutex.Lock()
+    delete(this.fixtures, token)
+    this.mutex.Unlock()
+}
+
+/**
+ * LoadFixtures adds the tokens of a JSON document mapping each token to its identity object
+ */
+func (this *Server) LoadFixtures(r io.Reader) error {
+    fixtures := map[string]json.RawMessage{}
+    if err := json.NewDecoder(r).Decode(&fixtures); err != nil {
+        return fmt.Errorf("arcauthtest: cannot decode fixtures: %v", err)
+    }
+    for token, identity := range fixtures {
+        this.AddTokenJSON(token, string(identity))
+    }
+    return nil
+}
+
+/**
+ * LoadFixturesFile is LoadFixtures reading from the file at path
+ */
+func (this *Server) LoadFixturesFile(path string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    return this.LoadFixtures(file)
+}
+
+/**
+ * Script queues behaviors for the next requests, each one applies to Times requests (1 when zero) in order and the
+ * fake goes back to normal once they are used up
+ */
+func (this *Server) Script(behaviors ...Behavior) {
+    this.mutex.Lock()
+    this.script = append(this.script, behaviors...)
+    this.mutex.Unlock()
+}
+
+/**
+ * ResetScript drops the behaviors that were not used yet
+ */
+func (this *Server) ResetScript() {
+    this.mutex.Lock()
+    this.script = nil
+    this.mutex.Unlock()
+}
+
+/**
+ * Requests returns how many requests reached the fake, including rejected ones
+ */
+func (this *Server) Requests() int {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return this.requests
+}
+
+/**
+ * Behavior is a scripted deviation from the normal answer of the fake
+ *
+ * Latency is waited first (or until the client gives up), then Drop closes the connection without an answer, a
+ * non-zero Status is sent with Body instead of looking the token up.  Behaviors only apply to authorized peers, a
+ * request the fake rejects with 401 (or 404 and 405) does not use them up.
+ */
+type Behavior struct {
+    Latency time.Duration
+    Status  int
+    Body    string
+    Drop    bool
+    Times   int
+}
+
+/**
+ * Latency delays the answer of the next request, which is otherwise answered normally
+ */
+func Latency(d time.Duration) Behavior {
+    return Behavior{Latency: d}
+}
+
+/**
+ * Burst answers the next n requests with status, e.g. Burst(503, 3)
+ */
+func Burst(status, n int) Behavior {
+    return Behavior{Status: status, Body: http.StatusText(status), Times: n}
+}
+
+/**
+ * NoContent answers the next request with 204 whatever the token
+ */
+func NoContent() Behavior {
+    return Behavior{Status: http.StatusNoContent}
+}
+
+/**
+ * DropConnection closes the connection of the next request without answering
+ *
+ * Note that net/http silently sends a GET again when a reused keep-alive connection is closed before any answer,
+ * disable keep-alives on the client's transport for every drop to reach the caller.
+ */
+func DropConnection() Behavior {
+    return Behavior{Drop: true}
+}
+
+func (this *Server) next() (Behavior, bool) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    if len(this.script) == 0 {
+        return Behavior{}, false
+    }
+    behavior := this.script[0]
+    if behavior.Times > 1 {
+        this.script[0].Times--
+    } else {
+        this.script = this.script[1:]
+    }
+    return behavior, true
+}
+
+func (this *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+    user, pass, ok := r.BasicAuth()
+    this.mutex.Lock()
+    this.requests++
+    authorized := ok && user == this.user && pass == this.pass
+    this.mutex.Unlock()
+
+    if r.URL.Path != AuthPath {
+        http.NotFound(w, r)
+        return
+    }
+    if r.Method != "GET" {
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+    // the peer is checked before any scripted behavior, like the real server authenticates before doing anything
+    if !authorized {
+        w.Header().Set("WWW-Authenticate", `Basic realm="arc-auth"`)
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusUnauthorized)
+        io.WriteString(w, `{"code":401,"message":"Unauthorized peer"}`)
+        return
+    }
+
+    if behavior, scripted := this.next(); scripted {
+        if behavior.Latency > 0 {
+            select {
+            case <-time.After(behavior.Latency):
+            case <-r.Context().Done():
+                return
+            }
+        }
+        if behavior.Drop {
+            drop(w)
+            return
+        }
+        if behavior.Status != 0 {
+            w.WriteHeader(behavior.Status)
+            io.WriteString(w, behavior.Body)
+            return
+        }
+    }
+
+    this.mutex.Lock()
+    identity, found := this.fixtures[r.Header.Get(AdmiralTokenHeader)]
+    this.mutex.Unlock()
+    if !found {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    io.WriteString(w, identity)
+}
+
+/**
+ * drop closes the underlying connection so the client sees it reset without any answer
+ */
+func drop(w http.ResponseWriter) {
+    hijacker, ok := w.(http.Hijacker)
+    if !ok {
+        panic("arcauthtest: the response writer cannot be hijacked to drop the connection")
+    }
+    conn, _, err := hijacker.Hijack()
+    if err == nil {
+        conn.Close()
+    }
+}
@@ -0,0 +1,157 @@
+package arcauthtest_test
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client"
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func newClient(t *testing.T, server *arcauthtest.Server) *arcauth.ArcAuthClient {
+    client, err := arcauth.New(server.URL, server.User(), server.Pass())
+    if err != nil {
+        t.Fatalf("unexpected error creating the ArcAuthClient %v", err)
+    }
+    return client
+}
+
+func TestServerAnswersFixtures(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    result, err := newClient(t, server).Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+    assert.Equal(t, "vaughant", result.User.Username)
+}
+
+func TestServerAnswersNoContentForUnknownTokens(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    body, err := newClient(t, server).Auth("No Such Token")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "{}", body)
+}
+
+func TestServerEnforcesPeerBasicAuth(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    client, _ := arcauth.New(server.URL, arcauthtest.DemoUser, "wrong")
+    _, err := client.Authenticate("FakeDemoToken")
+
+    errorResponse, ok := err.(*arcauth.ErrorResponse)
+    assert.True(t, ok, "expected an *ErrorResponse but got %v", err)
+    if ok {
+        assert.Equal(t, 401, errorResponse.Code)
+    }
+}
+
+func TestServerChecksPeerBeforeScript(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    server.Script(arcauthtest.NoContent())
+
+    client, _ := arcauth.New(server.URL, arcauthtest.DemoUser, "wrong")
+    _, err := client.Authenticate("FakeDemoToken")
+    assert.True(t, errors.Is(err, arcauth.ErrPeerUnauthorized), "expected a 401 but got %v", err)
+
+    result, err := newClient(t, server).Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+    assert.False(t, result.Valid, "the rejected request did not use the script up")
+}
+
+func TestServerSetPeer(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    server.SetPeer("rotated-app", "rotated-pass")
+
+    assert.Equal(t, "rotated-app", server.User())
+    assert.Equal(t, "rotated-pass", server.Pass())
+    result, err := newClient(t, server).Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+}
+
+func TestServerFixtureManagement(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    client := newClient(t, server)
+
+    server.AddToken("NewToken", map[string]interface{}{"user": map[string]string{"username": "newbie"}})
+    result, _ := client.Authenticate("NewToken")
+    assert.Equal(t, "newbie", result.User.Username)
+
+    assert.NoError(t, server.LoadFixtures(strings.NewReader(`{"Loaded": {"user": {"username": "loaded"}}}`)))
+    result, _ = client.Authenticate("Loaded")
+    assert.Equal(t, "loaded", result.User.Username)
+
+    server.RemoveToken("NewToken")
+    result, _ = client.Authenticate("NewToken")
+    assert.False(t, result.Valid)
+
+    assert.Error(t, server.LoadFixtures(strings.NewReader(`not json`)))
+}
+
+func TestServerScriptedBehaviors(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    client := newClient(t, server)
+
+    // a reused keep-alive connection would make the transport send the GET again after the drop
+    client.HttpClient.Transport = &http.Transport{DisableKeepAlives: true}
+    server.Script(arcauthtest.Burst(503, 2), arcauthtest.NoContent(), arcauthtest.DropConnection())
+
+    _, err := client.Authenticate("FakeDemoToken")
+    assert.Error(t, err)
+    _, err = client.Authenticate("FakeDemoToken")
+    assert.Error(t, err)
+    result, err := client.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+    assert.False(t, result.Valid, "the scripted 204 overrides the fixture")
+    _, err = client.Authenticate("FakeDemoToken")
+    assert.Error(t, err, "the dropped connection is an error")
+
+    result, err = client.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+    assert.True(t, result.Valid, "the fake is back to normal once the script is used up")
+    assert.Equal(t, 5, server.Requests())
+}
+
+func TestServerScriptedLatency(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    client := newClient(t, server)
+
+    server.Script(arcauthtest.Latency(time.Second))
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    _, err := client.AuthenticateContext(ctx, "FakeDemoToken")
+
+    assert.Error(t, err)
+}
+
+func TestServerWithRetryPolicy(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    client := newClient(t, server)
+    client.Retry = arcauth.DefaultRetryPolicy()
+    client.Retry.BaseDelay = time.Millisecond
+
+    server.Script(arcauthtest.Burst(502, 2))
+    result, err := client.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "vaughant", result.User.Username)
+    assert.Equal(t, 3, server.Requests())
+}
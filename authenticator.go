@@ -0,0 +1,126 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+)
+
+/**
+ * Authenticator validates tokens, ArcAuthClient is the implementation that asks the arc-auth-server
+ *
+ * Code that only needs to validate tokens (handlers, the Middleware, tests) should depend on this interface so that
+ * the client can be mocked with an AuthenticatorFunc or wrapped in decorators such as Cached or Retrying.
+ */
+type Authenticator interface {
+    AuthenticateContext(ctx context.Context, token string) (*AuthResult, error)
+}
+
+/**
+ * AuthenticatorFunc lets an ordinary function be used as an Authenticator
+ */
+type AuthenticatorFunc func(ctx context.Context, token string) (*AuthResult, error)
+
+func (this AuthenticatorFunc) AuthenticateContext(ctx context.Context, token string) (*AuthResult, error) {
+    return this(ctx, token)
+}
+
+var _ Authenticator = (*ArcAuthClient)(nil)
+
+/**
+ * Decorator adds behavior around an Authenticator
+ *
+ * Decorators are named after the behavior they add (Cached, Retrying, ...), the With functions are Options of New.
+ */
+type Decorator func(next Authenticator) Authenticator
+
+/**
+ * Chain wraps authenticator in the decorators, the first decorator is the outermost one
+ *
+ * Chain(client, Cached(cache), Retrying(policy)) looks tokens up in the cache before retrying the client.
+ */
+func Chain(authenticator Authenticator, decorators ...Decorator) Authenticator {
+    for i := len(decorators) - 1; i >= 0; i-- {
+        authenticator = decorators[i](authenticator)
+    }
+    return authenticator
+}
+
+/**
+ * Cached answers from cache when it can and stores the results of the wrapped Authenticator in it
+ */
+func Cached(cache *Cache) Decorator {
+    return func(next Authenticator) Authenticator {
+        return AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+            if result, found := cache.Get(token); found {
+                return result, nil
+            }
+            result, err := next.AuthenticateContext(ctx, token)
+            if err == nil {
+                cache.Set(token, result)
+            }
+            return result, err
+        })
+    }
+}
+
+/**
+ * Retrying tries the wrapped Authenticator again according to policy
+ */
+func Retrying(policy *RetryPolicy) Decorator {
+    return func(next Authenticator) Authenticator {
+        return AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+            return policy.do(ctx, func(ctx context.Context) (*AuthResult, error) {
+                return next.AuthenticateContext(ctx, token)
+            }, nil)
+        })
+    }
+}
+
+/**
+ * GuardedBy fails fast with ErrCircuitOpen while breaker is open
+ */
+func GuardedBy(breaker *CircuitBreaker) Decorator {
+    return func(next Authenticator) Authenticator {
+        return AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+            done, err := breaker.Allow()
+            if err != nil {
+                return nil, err
+            }
+            result, err := next.AuthenticateContext(ctx, token)
+            done(err)
+            return result, err
+        })
+    }
+}
+
+/**
+ * Coalescing makes concurrent calls for the same token share a single call to the wrapped Authenticator
+ */
+func Coalescing() Decorator {
+    return func(next Authenticator) Authenticator {
+        flights := &flightGroup{}
+        return AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+            result, err, _ := flights.do(ctx, token, func(ctx context.Context) (*AuthResult, error) {
+                return next.AuthenticateContext(ctx, token)
+            })
+            return result, err
+        })
+    }
+}
+
+/**
+ * FallingBackTo asks fallback when the wrapped Authenticator fails, e.g. a secondary arc-auth-server
+ *
+ * Invalid tokens are not errors and are not retried with fallback, neither are calls the caller gave up on.
+ */
+func FallingBackTo(fallback Authenticator) Decorator {
+    return func(next Authenticator) Authenticator {
+        return AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+            result, err := next.AuthenticateContext(ctx, token)
+            if err == nil || errors.Is(err, ErrCanceled) || errors.Is(err, ErrDeadlineExceeded) || ctx.Err() != nil {
+                return result, err
+            }
+            return fallback.AuthenticateContext(ctx, token)
+        })
+    }
+}
@@ -0,0 +1,147 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+/**
+ * countingAuthenticator answers with the given results in order, repeating the last one
+ */
+type countingAuthenticator struct {
+    calls   int32
+    results []*AuthResult
+    errs    []error
+}
+
+func (this *countingAuthenticator) AuthenticateContext(ctx context.Context, token string) (*AuthResult, error) {
+    call := int(atomic.AddInt32(&this.calls, 1)) - 1
+    if call >= len(this.results) {
+        call = len(this.results) - 1
+    }
+    return this.results[call], this.errs[call]
+}
+
+func TestAuthenticatorFuncAsMock(t *testing.T) {
+    var authenticator Authenticator = AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+        return &AuthResult{Valid: true, User: AuthUser{Username: token}}, nil
+    })
+
+    handler := NewMiddleware(authenticator).Handler(usernameHandler)
+    recorder := serveWithToken(handler, "mocked")
+
+    assert.Equal(t, http.StatusOK, recorder.Code)
+    assert.Equal(t, "mocked", recorder.Body.String())
+}
+
+func TestChainOrder(t *testing.T) {
+    var order []string
+    tag := func(name string) Decorator {
+        return func(next Authenticator) Authenticator {
+            return AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+                order = append(order, name)
+                return next.AuthenticateContext(ctx, token)
+            })
+        }
+    }
+    base := AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+        order = append(order, "base")
+        return InvalidAuthResult(), nil
+    })
+
+    Chain(base, tag("outer"), tag("inner")).AuthenticateContext(context.Background(), "token")
+
+    assert.Equal(t, []string{"outer", "inner", "base"}, order)
+}
+
+func TestCachedDecorator(t *testing.T) {
+    base := &countingAuthenticator{results: []*AuthResult{InvalidAuthResult()}, errs: []error{nil}}
+    authenticator := Chain(base, Cached(NewCache(10, time.Minute, time.Minute)))
+
+    authenticator.AuthenticateContext(context.Background(), "token")
+    authenticator.AuthenticateContext(context.Background(), "token")
+
+    assert.Equal(t, int32(1), atomic.LoadInt32(&base.calls))
+}
+
+func TestRetryingDecorator(t *testing.T) {
+    unavailable := &ErrorResponse{Code: http.StatusServiceUnavailable, Message: "Non-20X response code"}
+    base := &countingAuthenticator{
+        results: []*AuthResult{nil, InvalidAuthResult()},
+        errs:    []error{unavailable, nil},
+    }
+    authenticator := Chain(base, Retrying(fastRetryPolicy()))
+
+    result, err := authenticator.AuthenticateContext(context.Background(), "token")
+
+    assert.NoError(t, err)
+    assert.False(t, result.Valid)
+    assert.Equal(t, int32(2), atomic.LoadInt32(&base.calls))
+}
+
+func TestGuardedByDecorator(t *testing.T) {
+    base := &countingAuthenticator{results: []*AuthResult{nil}, errs: []error{errServerDown}}
+    authenticator := Chain(base, GuardedBy(NewCircuitBreaker(1, time.Minute)))
+
+    authenticator.AuthenticateContext(context.Background(), "token")
+    _, err := authenticator.AuthenticateContext(context.Background(), "token")
+
+    assert.Equal(t, ErrCircuitOpen, err)
+    assert.Equal(t, int32(1), atomic.LoadInt32(&base.calls))
+}
+
+func TestFallingBackToDecorator(t *testing.T) {
+    primary := &countingAuthenticator{results: []*AuthResult{nil}, errs: []error{errServerDown}}
+    secondary := &countingAuthenticator{results: []*AuthResult{InvalidAuthResult()}, errs: []error{nil}}
+    authenticator := Chain(primary, FallingBackTo(secondary))
+
+    result, err := authenticator.AuthenticateContext(context.Background(), "token")
+
+    assert.NoError(t, err)
+    assert.NotNil(t, result)
+    assert.Equal(t, int32(1), atomic.LoadInt32(&secondary.calls))
+}
+
+func TestFallingBackToNotUsedForInvalidTokensOrCancellation(t *testing.T) {
+    primary := &countingAuthenticator{results: []*AuthResult{InvalidAuthResult(), nil}, errs: []error{nil, ErrCanceled}}
+    secondary := &countingAuthenticator{results: []*AuthResult{InvalidAuthResult()}, errs: []error{nil}}
+    authenticator := Chain(primary, FallingBackTo(secondary))
+
+    authenticator.AuthenticateContext(context.Background(), "token")
+    _, err := authenticator.AuthenticateContext(context.Background(), "token")
+
+    assert.True(t, errors.Is(err, ErrCanceled))
+    assert.Equal(t, int32(0), atomic.LoadInt32(&secondary.calls))
+}
+
+func TestCoalescingDecorator(t *testing.T) {
+    release := make(chan struct{})
+    var calls int32
+    base := AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+        atomic.AddInt32(&calls, 1)
+        <-release
+        return InvalidAuthResult(), nil
+    })
+    authenticator := Chain(base, Coalescing())
+
+    done := make(chan struct{})
+    for i := 0; i < 5; i++ {
+        go func() {
+            authenticator.AuthenticateContext(context.Background(), "token")
+            done <- struct{}{}
+        }()
+    }
+    time.Sleep(20 * time.Millisecond)
+    close(release)
+    for i := 0; i < 5; i++ {
+        <-done
+    }
+
+    assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,39 @@
+package arcauth
+
+/**
+ * HasRole tells whether the authenticated user has role, it is false for an invalid or nil result
+ */
+func (this *AuthResult) HasRole(role string) bool {
+    if !this.authenticated() {
+        return false
+    }
+    for _, granted := range this.Roles {
+        if granted == role {
+            return true
+        }
+    }
+    return false
+}
+
+/**
+ * HasAnyRole tells whether the authenticated user has at least one of roles
+ */
+func (this *AuthResult) HasAnyRole(roles ...string) bool {
+    for _, role := range roles {
+        if this.HasRole(role) {
+            return true
+        }
+    }
+    return false
+}
+
+/**
+ * InOrganization tells whether the authenticated user belongs to the organization with the given ID
+ */
+func (this *AuthResult) InOrganization(id int64) bool {
+    return this.authenticated() && this.Organization.ID == id
+}
+
+func (this *AuthResult) authenticated() bool {
+    return this != nil && this.Valid
+}
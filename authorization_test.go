@@ -0,0 +1,85 @@
+package arcauth
+
+import (
+    "net"
+    "testing"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+/**
+ * fixture decodes the identity the fake arc-auth-server returns for token, see TestHelpersAgainstBoot2DockerImage for
+ * the identity a real arc-auth-server returns
+ */
+func fixture(t *testing.T, token string) *AuthResult {
+    result, err := DecodeAuthResult([]byte(arcauthtest.DefaultFixtures()[token]))
+    assert.NoError(t, err)
+    return result
+}
+
+func TestHasRole(t *testing.T) {
+    vaughant, reader := fixture(t, "FakeDemoToken"), fixture(t, "FakeReaderToken")
+
+    assert.True(t, vaughant.HasRole("admin"))
+    assert.True(t, vaughant.HasRole("editor"))
+    assert.False(t, vaughant.HasRole("reader"))
+    assert.False(t, vaughant.HasRole("Admin"), "roles are case sensitive")
+    assert.True(t, reader.HasRole("reader"))
+    assert.False(t, reader.HasRole(""))
+}
+
+func TestHasAnyRole(t *testing.T) {
+    vaughant, reader := fixture(t, "FakeDemoToken"), fixture(t, "FakeReaderToken")
+
+    assert.True(t, vaughant.HasAnyRole("reader", "editor"))
+    assert.False(t, reader.HasAnyRole("admin", "editor"))
+    assert.False(t, vaughant.HasAnyRole())
+}
+
+func TestMembership(t *testing.T) {
+    vaughant, reader := fixture(t, "FakeDemoToken"), fixture(t, "FakeReaderToken")
+
+    assert.True(t, vaughant.InOrganization(3))
+    assert.True(t, reader.InOrganization(3))
+    assert.False(t, vaughant.InOrganization(4))
+}
+
+func TestHelpersOnInvalidResult(t *testing.T) {
+    var missing *AuthResult
+    for _, result := range []*AuthResult{InvalidAuthResult(), missing} {
+        assert.False(t, result.HasRole("admin"))
+        assert.False(t, result.HasAnyRole("admin"))
+        assert.False(t, result.InOrganization(0))
+    }
+}
+
+func TestHelpersEndToEnd(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, result.HasRole("admin"))
+    assert.True(t, result.InOrganization(3))
+}
+
+func TestHelpersAgainstBoot2DockerImage(t *testing.T) {
+    // See runBoot2DockerTest, the roles and organization of vaughant come from the arc-auth-server's fixture database
+    if _, err := net.Dial("tcp", localhostServer); err != nil {
+        t.Skip("This test won't run unless it can reach ", localhostServer)
+    }
+    arcAuthClient, _ := New("http://" + localhostServer, "demo-app", "WKZd$&vk&$I7VCa@ueVl1sMMj7iFW315")
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "vaughant", result.User.Username)
+    for _, role := range result.Roles {
+        assert.True(t, result.HasRole(role), role)
+    }
+    assert.Equal(t, len(result.Roles) > 0, result.HasAnyRole(result.Roles...))
+    assert.True(t, result.InOrganization(result.Organization.ID))
+}
@@ -0,0 +1,87 @@
+package arcauth
+
+import (
+    "context"
+    "sync"
+)
+
+// DefaultBatchConcurrency is how many tokens AuthMany validates at once when BatchConcurrency is not set
+const DefaultBatchConcurrency = 8
+
+/**
+ * BatchResult is the outcome for one token of a batch, Err is set when the token could not be checked
+ */
+type BatchResult struct {
+    Token  string
+    Result *AuthResult
+    Err    error
+}
+
+/**
+ * AuthMany validates tokens with at most BatchConcurrency (DefaultBatchConcurrency when zero) calls in flight
+ *
+ * The results are in the order of tokens.  Repeated tokens are only validated once and share their result.  Once ctx
+ * is done no new token is started, the tokens that were not checked get ErrCanceled or ErrDeadlineExceeded.
+ */
+func (this *ArcAuthClient) AuthMany(ctx context.Context, tokens []string) []BatchResult {
+    return AuthenticateMany(ctx, this, tokens, this.BatchConcurrency)
+}
+
+/**
+ * AuthenticateMany is AuthMany for any Authenticator, concurrency bounds the calls in flight (DefaultBatchConcurrency
+ * when not positive)
+ */
+func AuthenticateMany(ctx context.Context, authenticator Authenticator, tokens []string, concurrency int) []BatchResult {
+    if concurrency < 1 {
+        concurrency = DefaultBatchConcurrency
+    }
+
+    // each distinct token is checked once, its outcome is copied to every position it appears at
+    positions := make(map[string][]int, len(tokens))
+    var distinct []string
+    for i, token := range tokens {
+        if _, seen := positions[token]; !seen {
+            distinct = append(distinct, token)
+        }
+        positions[token] = append(positions[token], i)
+    }
+
+    results := make([]BatchResult, len(tokens))
+    work := make(chan string)
+    var wait sync.WaitGroup
+    if concurrency > len(distinct) {
+        concurrency = len(distinct)
+    }
+    for i := 0; i < concurrency; i++ {
+        wait.Add(1)
+        go func() {
+            defer wait.Done()
+            for token := range work {
+                result, err := authenticator.AuthenticateContext(ctx, token)
+                for _, position := range positions[token] {
+                    results[position] = BatchResult{Token: token, Result: result, Err: err}
+                }
+            }
+        }()
+    }
+
+    next := 0
+    feed:
+    for ; next < len(distinct); next++ {
+        select {
+        case work <- distinct[next]:
+        case <-ctx.Done():
+            break feed
+        }
+    }
+    close(work)
+    wait.Wait()
+
+    for _, token := range distinct[next:] {
+        err := contextError(ctx, ctx.Err())
+        for _, position := range positions[token] {
+            results[position] = BatchResult{Token: token, Err: err}
+        }
+    }
+    return results
+}
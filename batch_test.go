@@ -0,0 +1,109 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestAuthManyKeepsInputOrder(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+
+    results := arcAuthClient.AuthMany(context.Background(), []string{"FakeReaderToken", "No Such Token", "FakeDemoToken"})
+
+    assert.Len(t, results, 3)
+    assert.Equal(t, "reader", results[0].Result.User.Username)
+    assert.False(t, results[1].Result.Valid)
+    assert.Equal(t, "vaughant", results[2].Result.User.Username)
+    for i, token := range []string{"FakeReaderToken", "No Such Token", "FakeDemoToken"} {
+        assert.Equal(t, token, results[i].Token)
+        assert.NoError(t, results[i].Err)
+    }
+}
+
+func TestAuthManyDeduplicatesTokens(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+
+    results := arcAuthClient.AuthMany(context.Background(), []string{"FakeDemoToken", "FakeReaderToken", "FakeDemoToken", "FakeDemoToken"})
+
+    assert.Equal(t, 2, server.Requests())
+    assert.Equal(t, "vaughant", results[3].Result.User.Username)
+}
+
+func TestAuthManyReportsPerTokenErrors(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.BatchConcurrency = 1
+
+    server.Script(arcauthtest.Latency(0), arcauthtest.Burst(503, 1))
+    results := arcAuthClient.AuthMany(context.Background(), []string{"FakeDemoToken", "FakeReaderToken", "No Such Token"})
+
+    assert.NoError(t, results[0].Err)
+    assert.Error(t, results[1].Err)
+    assert.NoError(t, results[2].Err)
+}
+
+func TestAuthenticateManyBoundsConcurrency(t *testing.T) {
+    var inFlight, peak int32
+    authenticator := AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+        current := atomic.AddInt32(&inFlight, 1)
+        for {
+            seen := atomic.LoadInt32(&peak)
+            if current <= seen || atomic.CompareAndSwapInt32(&peak, seen, current) {
+                break
+            }
+        }
+        time.Sleep(5 * time.Millisecond)
+        atomic.AddInt32(&inFlight, -1)
+        return InvalidAuthResult(), nil
+    })
+    tokens := make([]string, 50)
+    for i := range tokens {
+        tokens[i] = fmt.Sprintf("token-%d", i)
+    }
+
+    results := AuthenticateMany(context.Background(), authenticator, tokens, 4)
+
+    assert.Len(t, results, 50)
+    assert.True(t, atomic.LoadInt32(&peak) <= 4, "peak concurrency was %d", atomic.LoadInt32(&peak))
+    assert.True(t, atomic.LoadInt32(&peak) > 1)
+}
+
+func TestAuthenticateManyStopsWhenCanceled(t *testing.T) {
+    var calls int32
+    ctx, cancel := context.WithCancel(context.Background())
+    authenticator := AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+        if atomic.AddInt32(&calls, 1) == 3 {
+            cancel()
+        }
+        return InvalidAuthResult(), nil
+    })
+    tokens := make([]string, 100)
+    for i := range tokens {
+        tokens[i] = fmt.Sprintf("token-%d", i)
+    }
+
+    results := AuthenticateMany(ctx, authenticator, tokens, 1)
+
+    assert.True(t, atomic.LoadInt32(&calls) < 100, "made %d calls", atomic.LoadInt32(&calls))
+    assert.True(t, errors.Is(results[99].Err, ErrCanceled))
+    assert.Equal(t, "token-99", results[99].Token)
+    assert.NoError(t, results[0].Err)
+}
+
+func TestAuthenticateManyEmpty(t *testing.T) {
+    results := AuthenticateMany(context.Background(), AuthenticatorFunc(nil), nil, 4)
+
+    assert.Len(t, results, 0)
+}
@@ -0,0 +1,264 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+)
+
+/**
+ * ErrCircuitOpen is returned right away, without calling the arc-auth-server, while the circuit breaker is open, it
+ * also matches ErrUnavailable
+ */
+var ErrCircuitOpen error = &kindError{message: "arc-auth circuit breaker is open", kind: ErrUnavailable}
+
+/**
+ * BreakerState is the state of a CircuitBreaker
+ */
+type BreakerState int
+
+const (
+    // BreakerClosed lets every request through, it is the normal state
+    BreakerClosed BreakerState = iota
+    // BreakerOpen rejects every request with ErrCircuitOpen until the cool-down is over
+    BreakerOpen
+    // BreakerHalfOpen lets a few probe requests through to find out whether the server recovered
+    BreakerHalfOpen
+)
+
+func (this BreakerState) String() string {
+    switch this {
+    case BreakerClosed:
+        return "closed"
+    case BreakerOpen:
+        return "open"
+    case BreakerHalfOpen:
+        return "half-open"
+    }
+    return "unknown"
+}
+
+/**
+ * CircuitBreaker stops ArcAuthClient from calling an arc-auth-server that keeps failing
+ *
+ * After FailureThreshold consecutive failures (network errors and 5xx responses) the breaker opens and every call
+ * fails fast with ErrCircuitOpen.  Once CoolDown has passed it goes half-open and lets up to HalfOpenProbes requests
+ * through: SuccessThreshold successes close it again, a single failure re-opens it for another CoolDown.  This keeps
+ * goroutines from piling up on a dead server and avoids stampeding it when it comes back.
+ *
+ * OnStateChange is optional and called, outside of the breaker's lock, for every transition.
+ */
+type CircuitBreaker struct {
+    FailureThreshold int
+    SuccessThreshold int
+    HalfOpenProbes   int
+    CoolDown         time.Duration
+    OnStateChange    func(from, to BreakerState)
+
+    mutex            sync.Mutex
+    state            BreakerState
+    failures         int
+    successes        int
+    probes           int
+    openedAt         time.Time
+    // generation counts the transitions, an outcome is only recorded in the generation its request was allowed in
+    generation       uint64
+    now              func() time.Time
+}
+
+/**
+ * NewCircuitBreaker constructs a closed CircuitBreaker
+ * failureThreshold - the number of consecutive failures that opens the breaker
+ * coolDown - how long the breaker stays open before probing the server again
+ */
+func NewCircuitBreaker(failureThreshold int, coolDown time.Duration) *CircuitBreaker {
+    return &CircuitBreaker{
+        FailureThreshold: failureThreshold,
+        SuccessThreshold: 1,
+        HalfOpenProbes:   1,
+        CoolDown:         coolDown,
+        now:              time.Now,
+    }
+}
+
+/**
+ * State returns the current state, an open breaker whose cool-down is over reports BreakerHalfOpen
+ */
+func (this *CircuitBreaker) State() BreakerState {
+    this.mutex.Lock()
+    from, to := this.advance()
+    state := this.state
+    this.mutex.Unlock()
+    this.notify(from, to)
+    return state
+}
+
+/**
+ * Allow asks the breaker for permission to send a request, the caller must report the request's error (nil on
+ * success) through done.  Errors caused by the caller giving up are neither counted as a success nor a failure.
+ */
+func (this *CircuitBreaker) Allow() (done func(err error), err error) {
+    this.mutex.Lock()
+    from, to := this.advance()
+    switch this.state {
+    case BreakerOpen:
+        this.mutex.Unlock()
+        this.notify(from, to)
+        return nil, ErrCircuitOpen
+    case BreakerHalfOpen:
+        if this.probes >= this.maxProbes() {
+            this.mutex.Unlock()
+            this.notify(from, to)
+            return nil, ErrCircuitOpen
+        }
+        this.probes++
+    }
+    generation := this.generation
+    this.mutex.Unlock()
+    this.notify(from, to)
+
+    var once sync.Once
+    return func(err error) {
+        once.Do(func() { this.record(err, generation) })
+    }, nil
+}
+
+/**
+ * Reset forces the breaker back to closed
+ */
+func (this *CircuitBreaker) Reset() {
+    this.mutex.Lock()
+    from, to := this.transition(BreakerClosed)
+    this.mutex.Unlock()
+    this.notify(from, to)
+}
+
+/**
+ * record counts the outcome of a request allowed in generation, outcomes of requests allowed before the last transition
+ * say nothing about the current state and are dropped
+ */
+func (this *CircuitBreaker) record(err error, generation uint64) {
+    // a request that was given up or held back by the rate limiter says nothing about the server's health
+    unsent := errors.Is(err, ErrCanceled) || errors.Is(err, ErrDeadlineExceeded) || errors.Is(err, ErrRateLimited)
+    success := !isBreakerFailure(err)
+
+    this.mutex.Lock()
+    if generation != this.generation {
+        this.mutex.Unlock()
+        return
+    }
+    var from, to BreakerState
+    switch {
+    case unsent:
+        this.releaseProbe()
+    case this.state == BreakerClosed:
+        if success {
+            this.failures = 0
+        } else {
+            this.failures++
+            if this.failures >= this.FailureThreshold {
+                from, to = this.transition(BreakerOpen)
+            }
+        }
+    case this.state == BreakerHalfOpen:
+        this.releaseProbe()
+        if !success {
+            from, to = this.transition(BreakerOpen)
+        } else {
+            this.successes++
+            if this.successes >= this.SuccessThreshold {
+                from, to = this.transition(BreakerClosed)
+            }
+        }
+    }
+    this.mutex.Unlock()
+    this.notify(from, to)
+}
+
+/**
+ * releaseProbe frees a half-open probe slot, this.mutex must be held
+ */
+func (this *CircuitBreaker) releaseProbe() {
+    if this.state == BreakerHalfOpen && this.probes > 0 {
+        this.probes--
+    }
+}
+
+/**
+ * advance moves an open breaker to half-open once the cool-down is over, this.mutex must be held
+ */
+func (this *CircuitBreaker) advance() (from, to BreakerState) {
+    if this.state == BreakerOpen && !this.clock().Before(this.openedAt.Add(this.CoolDown)) {
+        return this.transition(BreakerHalfOpen)
+    }
+    return BreakerClosed, BreakerClosed
+}
+
+/**
+ * transition switches to state and resets the counters, this.mutex must be held
+ */
+func (this *CircuitBreaker) transition(state BreakerState) (from, to BreakerState) {
+    from = this.state
+    this.state = state
+    this.generation++
+    this.failures = 0
+    this.successes = 0
+    this.probes = 0
+    if state == BreakerOpen {
+        this.openedAt = this.clock()
+    }
+    return from, state
+}
+
+func (this *CircuitBreaker) notify(from, to BreakerState) {
+    if from != to && this.OnStateChange != nil {
+        this.OnStateChange(from, to)
+    }
+}
+
+func (this *CircuitBreaker) maxProbes() int {
+    if this.HalfOpenProbes < 1 {
+        return 1
+    }
+    return this.HalfOpenProbes
+}
+
+func (this *CircuitBreaker) clock() time.Time {
+    if this.now == nil {
+        return time.Now()
+    }
+    return this.now()
+}
+
+/**
+ * isBreakerFailure tells whether err means the arc-auth-server is unhealthy, as opposed to the caller giving up
+ * or the server rejecting the request on purpose
+ */
+func isBreakerFailure(err error) bool {
+    if err == nil || errors.Is(err, ErrCanceled) || errors.Is(err, ErrDeadlineExceeded) || errors.Is(err, ErrRateLimited) {
+        return false
+    }
+    var errorResponse *ErrorResponse
+    if errors.As(err, &errorResponse) {
+        return errorResponse.Code >= 500
+    }
+    var malformed *MalformedResponseError
+    return !errors.As(err, &malformed)
+}
+
+/**
+ * attempt sends a single request to the arc-auth-server, going through the circuit breaker when one is set
+ */
+func (this *ArcAuthClient) attempt(ctx context.Context, token string) (*AuthResult, error) {
+    if this.Breaker == nil {
+        return this.fetch(ctx, token)
+    }
+    done, err := this.Breaker.Allow()
+    if err != nil {
+        return nil, err
+    }
+    result, err := this.fetch(ctx, token)
+    done(err)
+    return result, err
+}
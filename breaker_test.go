@@ -0,0 +1,178 @@
+package arcauth
+
+import (
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+var errServerDown = errors.New("connection refused")
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+    breaker := NewCircuitBreaker(3, time.Minute)
+
+    for i := 0; i < 3; i++ {
+        done, err := breaker.Allow()
+        assert.NoError(t, err)
+        done(errServerDown)
+    }
+
+    assert.Equal(t, BreakerOpen, breaker.State())
+    _, err := breaker.Allow()
+    assert.Equal(t, ErrCircuitOpen, err)
+}
+
+func TestBreakerSuccessResetsFailureCount(t *testing.T) {
+    breaker := NewCircuitBreaker(2, time.Minute)
+
+    done, _ := breaker.Allow()
+    done(errServerDown)
+    done, _ = breaker.Allow()
+    done(nil)
+    done, _ = breaker.Allow()
+    done(errServerDown)
+
+    assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestBreakerIgnoresRejectionsAndCancellations(t *testing.T) {
+    breaker := NewCircuitBreaker(1, time.Minute)
+
+    done, _ := breaker.Allow()
+    done(&ErrorResponse{Code: http.StatusUnauthorized, Message: "Non-20X response code"})
+    done, _ = breaker.Allow()
+    done(ErrCanceled)
+
+    assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+    clock := newFakeClock()
+    breaker := NewCircuitBreaker(1, time.Minute)
+    breaker.now = clock.Now
+    var transitions []string
+    breaker.OnStateChange = func(from, to BreakerState) {
+        transitions = append(transitions, from.String() + "->" + to.String())
+    }
+
+    done, _ := breaker.Allow()
+    done(errServerDown)
+    clock.Advance(time.Minute)
+    assert.Equal(t, BreakerHalfOpen, breaker.State())
+
+    probe, err := breaker.Allow()
+    assert.NoError(t, err)
+    _, err = breaker.Allow()
+    assert.Equal(t, ErrCircuitOpen, err, "only one probe at a time while half-open")
+
+    probe(errServerDown)
+    assert.Equal(t, BreakerOpen, breaker.State(), "a failed probe re-opens the breaker")
+
+    clock.Advance(time.Minute)
+    probe, _ = breaker.Allow()
+    probe(nil)
+    assert.Equal(t, BreakerClosed, breaker.State())
+
+    assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}, transitions)
+}
+
+func TestBreakerIgnoresOutcomesFromBeforeTransition(t *testing.T) {
+    clock := newFakeClock()
+    breaker := NewCircuitBreaker(1, time.Minute)
+    breaker.now = clock.Now
+
+    staleSuccess, _ := breaker.Allow()
+    staleFailure, _ := breaker.Allow()
+    opening, _ := breaker.Allow()
+    opening(errServerDown)
+    clock.Advance(time.Minute)
+    probe, err := breaker.Allow()
+    assert.NoError(t, err)
+    assert.Equal(t, BreakerHalfOpen, breaker.State())
+
+    staleSuccess(nil)
+    assert.Equal(t, BreakerHalfOpen, breaker.State(), "a success allowed while closed does not close the breaker")
+    staleFailure(errServerDown)
+    assert.Equal(t, BreakerHalfOpen, breaker.State(), "a failure allowed while closed does not re-open the breaker")
+    _, err = breaker.Allow()
+    assert.Equal(t, ErrCircuitOpen, err, "the stale outcomes did not free the probe slot")
+
+    probe(nil)
+    assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestBreakerReportsEachOutcomeOnce(t *testing.T) {
+    breaker := NewCircuitBreaker(2, time.Minute)
+
+    done, _ := breaker.Allow()
+    done(errServerDown)
+    done(errServerDown)
+
+    assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestClientFailsFastWhenBreakerIsOpen(t *testing.T) {
+    var calls int32
+    testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&calls, 1)
+        w.WriteHeader(http.StatusBadGateway)
+    }))
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Breaker = NewCircuitBreaker(2, time.Minute)
+
+    for i := 0; i < 5; i++ {
+        arcAuthClient.Authenticate("FakeDemoToken")
+    }
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrCircuitOpen))
+    assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestClientBreakerWithRetryDoesNotRetryOpenCircuit(t *testing.T) {
+    var calls int32
+    testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&calls, 1)
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }))
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Retry = fastRetryPolicy()
+    arcAuthClient.Breaker = NewCircuitBreaker(1, time.Minute)
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    var retryError *RetryError
+    assert.True(t, errors.As(err, &retryError))
+    assert.Len(t, retryError.Attempts, 2)
+    assert.True(t, errors.Is(err, ErrCircuitOpen))
+    assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestBreakerConcurrentUse(t *testing.T) {
+    breaker := NewCircuitBreaker(1000000, time.Minute)
+    var wait sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wait.Add(1)
+        go func() {
+            defer wait.Done()
+            for j := 0; j < 100; j++ {
+                if done, err := breaker.Allow(); err == nil {
+                    done(nil)
+                }
+            }
+        }()
+    }
+    wait.Wait()
+
+    assert.Equal(t, BreakerClosed, breaker.State())
+}
@@ -0,0 +1,220 @@
+package arcauth
+
+import (
+    "container/list"
+    "crypto/sha256"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    maxCacheShards     = 16
+    minEntriesPerShard = 256
+)
+
+/**
+ * Cache is an in-process, size bounded, LRU cache of token validation results that ArcAuthClient consults before
+ * calling the arc-auth-server
+ *
+ * Valid and invalid tokens expire after separate TTLs, a TTL of zero means results of that kind are never cached.
+ * Tokens are only kept as SHA-256 digests, never in plaintext.  A Cache is safe for concurrent use, large caches are
+ * split in shards that each hold their own lock and LRU order so that heavy traffic does not contend on a single mutex.
+ */
+type Cache struct {
+    ValidTTL   time.Duration
+    InvalidTTL time.Duration
+
+    shards    []cacheShard
+    hits      uint64
+    misses    uint64
+    evictions uint64
+    now       func() time.Time
+}
+
+/**
+ * CacheStats is a snapshot of the counters of a Cache, use it to tune the size and TTLs
+ */
+type CacheStats struct {
+    Hits      uint64
+    Misses    uint64
+    Evictions uint64
+    Size      int
+}
+
+/**
+ * HitRate is the fraction of lookups that were answered from the cache, 0 when nothing was looked up yet
+ */
+func (this CacheStats) HitRate() float64 {
+    total := this.Hits + this.Misses
+    if total == 0 {
+        return 0
+    }
+    return float64(this.Hits) / float64(total)
+}
+
+type tokenKey [sha256.Size]byte
+
+func keyFor(token string) tokenKey {
+    return tokenKey(sha256.Sum256([]byte(token)))
+}
+
+type cacheEntry struct {
+    key     tokenKey
+    result  *AuthResult
+    expires time.Time
+}
+
+type cacheShard struct {
+    mutex    sync.Mutex
+    capacity int
+    entries  map[tokenKey]*list.Element
+    order    *list.List
+}
+
+/**
+ * NewCache constructs a Cache holding at most maxEntries results
+ * maxEntries - the upper bound of cached tokens, the least recently used ones are evicted first (must be positive)
+ * validTTL - how long the result for a valid token is reused
+ * invalidTTL - how long the result for an invalid (204) token is reused, keep it short so new tokens work quickly
+ */
+func NewCache(maxEntries int, validTTL, invalidTTL time.Duration) *Cache {
+    if maxEntries < 1 {
+        maxEntries = 1
+    }
+    shards := maxEntries / minEntriesPerShard
+    if shards < 1 {
+        shards = 1
+    }
+    if shards > maxCacheShards {
+        shards = maxCacheShards
+    }
+
+    cache := &Cache{ValidTTL: validTTL, InvalidTTL: invalidTTL, shards: make([]cacheShard, shards), now: time.Now}
+    for i := range cache.shards {
+        // spread maxEntries over the shards, the first ones get the remainder
+        capacity := maxEntries / shards
+        if i < maxEntries % shards {
+            capacity++
+        }
+        cache.shards[i] = cacheShard{
+            capacity: capacity,
+            entries:  make(map[tokenKey]*list.Element),
+            order:    list.New(),
+        }
+    }
+    return cache
+}
+
+/**
+ * Get returns the cached result for token, the second value is false when it is missing or expired
+ *
+ * The returned AuthResult is shared with other callers and must not be modified
+ */
+func (this *Cache) Get(token string) (*AuthResult, bool) {
+    key := keyFor(token)
+    shard := this.shard(key)
+
+    shard.mutex.Lock()
+    element, found := shard.entries[key]
+    if found {
+        entry := element.Value.(*cacheEntry)
+        if this.now().Before(entry.expires) {
+            shard.order.MoveToFront(element)
+            shard.mutex.Unlock()
+            atomic.AddUint64(&this.hits, 1)
+            return entry.result, true
+        }
+        shard.remove(element)
+    }
+    shard.mutex.Unlock()
+    atomic.AddUint64(&this.misses, 1)
+    return nil, false
+}
+
+/**
+ * Set stores result for token according to the TTL of its kind, evicting the least recently used entry when full
+ */
+func (this *Cache) Set(token string, result *AuthResult) {
+    if result == nil {
+        return
+    }
+    ttl := this.InvalidTTL
+    if result.Valid {
+        ttl = this.ValidTTL
+    }
+    if ttl <= 0 {
+        return
+    }
+
+    key := keyFor(token)
+    shard := this.shard(key)
+    entry := &cacheEntry{key: key, result: result, expires: this.now().Add(ttl)}
+
+    shard.mutex.Lock()
+    defer shard.mutex.Unlock()
+    if element, found := shard.entries[key]; found {
+        element.Value = entry
+        shard.order.MoveToFront(element)
+        return
+    }
+    for shard.order.Len() >= shard.capacity {
+        shard.remove(shard.order.Back())
+        atomic.AddUint64(&this.evictions, 1)
+    }
+    shard.entries[key] = shard.order.PushFront(entry)
+}
+
+/**
+ * Delete forgets the cached result for token, e.g. right after a user logged out
+ */
+func (this *Cache) Delete(token string) {
+    key := keyFor(token)
+    shard := this.shard(key)
+
+    shard.mutex.Lock()
+    if element, found := shard.entries[key]; found {
+        shard.remove(element)
+    }
+    shard.mutex.Unlock()
+}
+
+/**
+ * Purge empties the cache, the hit/miss counters are kept
+ */
+func (this *Cache) Purge() {
+    for i := range this.shards {
+        shard := &this.shards[i]
+        shard.mutex.Lock()
+        shard.entries = make(map[tokenKey]*list.Element)
+        shard.order.Init()
+        shard.mutex.Unlock()
+    }
+}
+
+/**
+ * Stats returns the current hit, miss and eviction counters and the number of cached tokens
+ */
+func (this *Cache) Stats() CacheStats {
+    stats := CacheStats{
+        Hits:      atomic.LoadUint64(&this.hits),
+        Misses:    atomic.LoadUint64(&this.misses),
+        Evictions: atomic.LoadUint64(&this.evictions),
+    }
+    for i := range this.shards {
+        shard := &this.shards[i]
+        shard.mutex.Lock()
+        stats.Size += shard.order.Len()
+        shard.mutex.Unlock()
+    }
+    return stats
+}
+
+func (this *Cache) shard(key tokenKey) *cacheShard {
+    return &this.shards[int(key[0]) % len(this.shards)]
+}
+
+func (this *cacheShard) remove(element *list.Element) {
+    this.order.Remove(element)
+    delete(this.entries, element.Value.(*cacheEntry).key)
+}
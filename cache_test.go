@@ -0,0 +1,183 @@
+package arcauth
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+    mutex sync.Mutex
+    now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+    return &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (this *fakeClock) Now() time.Time {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return this.now
+}
+
+func (this *fakeClock) Advance(d time.Duration) {
+    this.mutex.Lock()
+    this.now = this.now.Add(d)
+    this.mutex.Unlock()
+}
+
+func TestCacheHitAndMiss(t *testing.T) {
+    cache := NewCache(10, time.Minute, time.Second)
+    valid, _ := DecodeAuthResult([]byte(vaughantJSON))
+
+    _, found := cache.Get("FakeDemoToken")
+    assert.False(t, found)
+
+    cache.Set("FakeDemoToken", valid)
+    result, found := cache.Get("FakeDemoToken")
+    assert.True(t, found)
+    assert.Equal(t, "vaughant", result.User.Username)
+
+    stats := cache.Stats()
+    assert.Equal(t, uint64(1), stats.Hits)
+    assert.Equal(t, uint64(1), stats.Misses)
+    assert.Equal(t, 1, stats.Size)
+    assert.Equal(t, 0.5, stats.HitRate())
+}
+
+func TestCacheSeparateTTLs(t *testing.T) {
+    clock := newFakeClock()
+    cache := NewCache(10, time.Minute, time.Second)
+    cache.now = clock.Now
+    valid, _ := DecodeAuthResult([]byte(vaughantJSON))
+
+    cache.Set("good", valid)
+    cache.Set("bad", InvalidAuthResult())
+
+    clock.Advance(2 * time.Second)
+    _, found := cache.Get("bad")
+    assert.False(t, found, "invalid results expire after InvalidTTL")
+    _, found = cache.Get("good")
+    assert.True(t, found, "valid results are kept until ValidTTL")
+
+    clock.Advance(time.Minute)
+    _, found = cache.Get("good")
+    assert.False(t, found)
+    assert.Equal(t, 0, cache.Stats().Size, "expired entries are dropped on lookup")
+}
+
+func TestCacheZeroTTLDisablesKind(t *testing.T) {
+    cache := NewCache(10, time.Minute, 0)
+
+    cache.Set("bad", InvalidAuthResult())
+    _, found := cache.Get("bad")
+
+    assert.False(t, found)
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+    cache := NewCache(2, time.Minute, time.Minute)
+
+    cache.Set("one", InvalidAuthResult())
+    cache.Set("two", InvalidAuthResult())
+    cache.Get("one")
+    cache.Set("three", InvalidAuthResult())
+
+    _, found := cache.Get("two")
+    assert.False(t, found, "two was the least recently used entry")
+    _, found = cache.Get("one")
+    assert.True(t, found)
+    _, found = cache.Get("three")
+    assert.True(t, found)
+    assert.Equal(t, uint64(1), cache.Stats().Evictions)
+}
+
+func TestCacheStaysBoundedWhenSharded(t *testing.T) {
+    cache := NewCache(1000, time.Minute, time.Minute)
+
+    for i := 0; i < 5000; i++ {
+        cache.Set(fmt.Sprintf("token-%d", i), InvalidAuthResult())
+    }
+
+    assert.True(t, cache.Stats().Size <= 1000, "cache grew to %d entries", cache.Stats().Size)
+}
+
+func TestCacheDeleteAndPurge(t *testing.T) {
+    cache := NewCache(10, time.Minute, time.Minute)
+    cache.Set("one", InvalidAuthResult())
+    cache.Set("two", InvalidAuthResult())
+
+    cache.Delete("one")
+    _, found := cache.Get("one")
+    assert.False(t, found)
+
+    cache.Purge()
+    assert.Equal(t, 0, cache.Stats().Size)
+}
+
+func TestCacheConcurrentUse(t *testing.T) {
+    cache := NewCache(100, time.Minute, time.Minute)
+    var wait sync.WaitGroup
+    for i := 0; i < 50; i++ {
+        wait.Add(1)
+        go func(i int) {
+            defer wait.Done()
+            for j := 0; j < 200; j++ {
+                token := fmt.Sprintf("token-%d", (i + j) % 150)
+                if _, found := cache.Get(token); !found {
+                    cache.Set(token, InvalidAuthResult())
+                }
+            }
+        }(i)
+    }
+    wait.Wait()
+
+    stats := cache.Stats()
+    assert.Equal(t, uint64(50 * 200), stats.Hits + stats.Misses)
+    assert.True(t, stats.Size <= 100)
+}
+
+func TestClientUsesCache(t *testing.T) {
+    var calls int32
+    testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&calls, 1)
+        fmt.Fprint(w, vaughantJSON)
+    }))
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Cache = NewCache(10, time.Minute, time.Second)
+
+    for i := 0; i < 3; i++ {
+        body, err := arcAuthClient.Auth("FakeDemoToken")
+        assert.NoError(t, err)
+        assert.Equal(t, vaughantJSON, body)
+    }
+
+    assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+    assert.Equal(t, uint64(2), arcAuthClient.Cache.Stats().Hits)
+}
+
+func TestClientDoesNotCacheErrors(t *testing.T) {
+    var calls int32
+    testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&calls, 1)
+        http.Error(w, "something failed", http.StatusInternalServerError)
+    }))
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Cache = NewCache(10, time.Minute, time.Minute)
+
+    arcAuthClient.Auth("FakeDemoToken")
+    arcAuthClient.Auth("FakeDemoToken")
+
+    assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -2,20 +2,56 @@ package arcauth
 
 import (
     "bytes"
+    "context"
+    "errors"
     "fmt"
+    "io"
     "io/ioutil"
-    "log"
     "net/http"
+    "net/url"
     "strings"
+    "time"
 )
 
 const AdmiralTokenHeader = "X-Admiral-Token"
 
 type ArcAuthClient struct {
+    // Host is the arc-auth-server API URL, it is not used when Endpoints is set
     Host       string
     User       string
     Pass       string
+    // Credentials is optional, when set it is asked for the peer credentials on every request instead of User and Pass
+    Credentials CredentialProvider
     HttpClient *http.Client
+    // Doer is optional, when set it sends the requests instead of HttpClient
+    Doer       Doer
+    UserAgent  string
+
+    // Logger is optional, the client is silent without one
+    Logger     Logger
+    // Metrics is optional, when set calls and requests are counted and timed
+    Metrics    *Metrics
+    // Observer is optional, it is notified of every stage of a call
+    Observer   Observer
+
+    // Cache is optional, when set token validation results are reused until they expire
+    Cache      *Cache
+    // Coalesce makes concurrent calls for the same token share a single request to the arc-auth-server
+    Coalesce   bool
+    // Retry is optional, when set transient failures of the arc-auth-server are tried again
+    Retry      *RetryPolicy
+    // Breaker is optional, when set calls fail fast with ErrCircuitOpen while the arc-auth-server is unhealthy
+    Breaker    *CircuitBreaker
+    // Limiter is optional, when set requests to the arc-auth-server wait for it or fail with ErrRateLimited
+    Limiter    *RateLimiter
+    // Endpoints is optional, when set requests are balanced over several arc-auth-servers and fail over between them
+    Endpoints  *EndpointPool
+    // Hedge is optional, when set a request that is slow to answer is sent a second time and the first answer wins
+    Hedge      *HedgePolicy
+    // BatchConcurrency bounds the calls AuthMany makes at once, DefaultBatchConcurrency when zero
+    BatchConcurrency int
+
+    flights    flightGroup
 }
 
 
@@ -24,10 +60,9 @@ type ArcAuthClient struct {
  * server - the root FQDN of the arc-auth-server (e.g. https://arc-auth.ext.nile.works)
  * user - the user to use in BasicAuth when making requests for token authentication (this go client itself must be authenticated!)
  * pass - the password for the user when sending BasicAuth
+ * options - optional tuning of timeouts, transport, TLS, connection pool, user agent and API base path
  */
-func New(server, user string, pass string) (*ArcAuthClient, error) {
-    log.Printf("Constructing new arc-auth client")
-
+func New(server, user string, pass string, options ...Option) (*ArcAuthClient, error) {
     if server == "" {
         return nil, fmt.Errorf("Arc Auth Server cannot be empty, provide FQDN value like 'http://your.service.com'")
     }
@@ -38,11 +73,36 @@ func New(server, user string, pass string) (*ArcAuthClient, error) {
         return nil, fmt.Errorf("You must provide a password to authenticate against the arc-auth server")
     }
 
+    config := defaultOptions()
+    for _, option := range options {
+        if err := option(config); err != nil {
+            return nil, err
+        }
+    }
+    httpClient, err := config.httpClient()
+    if err != nil {
+        return nil, err
+    }
+
+    var endpoints *EndpointPool
+    if len(config.endpoints) > 0 {
+        hosts := []string{fmt.Sprintf("%s%s", strings.TrimSuffix(server, "/"), config.basePath)}
+        for _, endpoint := range config.endpoints {
+            hosts = append(hosts, fmt.Sprintf("%s%s", strings.TrimSuffix(endpoint, "/"), config.basePath))
+        }
+        endpoints = NewEndpointPool(config.balancing, hosts...)
+    }
+
     return &ArcAuthClient {
-        Host:   fmt.Sprintf("%s/api/v1", server),
+        Host:   fmt.Sprintf("%s%s", strings.TrimSuffix(server, "/"), config.basePath),
         User:   user,
         Pass:   pass,
-        HttpClient: &http.Client{ },
+        HttpClient: httpClient,
+        Doer:   config.doer,
+        UserAgent: config.userAgent,
+        Logger: config.logger,
+        Credentials: config.credentials,
+        Endpoints: endpoints,
     }, nil
 }
 
@@ -51,47 +111,251 @@ func New(server, user string, pass string) (*ArcAuthClient, error) {
  * token string set as the Header associated to the AdmiralTokenHeader key
  *
  * On a succesful connection, the raw JSON from the server is returned by this method.  Note that an invalid
- * token will still be "successful" and a 204/Empty Content from the server will result in an empty string
- * being returned to the caller
+ * token will still be "successful" and a 204/Empty Content from the server will result in an empty JSON block
+ * ("{}") being returned to the caller
+ *
+ * Auth is kept for compatibility, new code should use Authenticate and its typed AuthResult instead
  */
 func (this *ArcAuthClient) Auth(token string) (string, error) {
-    request, err := http.NewRequest("GET", fmt.Sprintf("%s/auth", this.Host), nil)
-    request.SetBasicAuth(this.User, this.Pass)
-    request.Header.Set(AdmiralTokenHeader, token)
-
-    log.Printf("making request %v", request)
-    log.Printf("client.Auth(%s) with user(%s) and pass(%s)", token, this.User, this.Pass)
-    response, err := this.HttpClient.Do(request)
-    log.Printf("made request %v and response was %v and err was %v", request, response, err)
+    return this.AuthContext(context.Background(), token)
+}
 
-    defer response.Body.Close()
+/**
+ * AuthContext is Auth with a context that cancels the request to the arc-auth-server
+ */
+func (this *ArcAuthClient) AuthContext(ctx context.Context, token string) (string, error) {
+    result, err := this.AuthenticateContext(ctx, token)
+    var malformed *MalformedResponseError
+    if errors.As(err, &malformed) && malformed.Body != nil {
+        return string(malformed.Body), nil
+    }
     if err != nil {
-        log.Printf("Error : %s", err)
         return "", err
-    } 
+    }
+    if !result.Valid {
+        return "{}", nil
+    }
+    return string(result.Raw), nil
+}
 
+/**
+ * Authenticate makes the same request as Auth but decodes the server's answer into an AuthResult
+ *
+ * A 204/Empty Content from the server is not an error, it results in an AuthResult whose Valid flag is false
+ */
+func (this *ArcAuthClient) Authenticate(token string) (*AuthResult, error) {
+    return this.AuthenticateContext(context.Background(), token)
+}
+
+/**
+ * AuthenticateContext is Authenticate with a context whose cancellation and deadline apply to the outbound request
+ *
+ * When ctx is done before the server answered the returned error matches ErrCanceled or ErrDeadlineExceeded
+ * with errors.Is
+ */
+func (this *ArcAuthClient) AuthenticateContext(ctx context.Context, token string) (result *AuthResult, err error) {
+    if this.Metrics != nil {
+        done := this.Metrics.startCall()
+        defer func() { done(result, err) }()
+    }
+    observer := this.observer()
+    started := time.Now()
+    defer func() {
+        event := ObservedEvent{Token: this.Mask(token), Status: statusOf(result, err), Duration: time.Since(started), Err: err}
+        event.Valid = err == nil && result.Valid
+        observer.OnDecision(ctx, event)
+    }()
+
+    if this.Cache != nil {
+        result, found := this.Cache.Get(token)
+        if this.Metrics != nil {
+            this.Metrics.cacheLookup(found)
+        }
+        observer.OnCache(ctx, ObservedEvent{Token: this.Mask(token), CacheHit: found})
+        if found {
+            return result, nil
+        }
+    }
+
+    if this.Coalesce {
+        result, err, _ := this.flights.do(ctx, token, func(ctx context.Context) (*AuthResult, error) {
+            return this.fetchAndCache(ctx, token)
+        })
+        return result, err
+    }
+    return this.fetchAndCache(ctx, token)
+}
+
+func (this *ArcAuthClient) fetchAndCache(ctx context.Context, token string) (*AuthResult, error) {
+    result, err := this.fetchWithRetry(ctx, token)
+    if err == nil && this.Cache != nil {
+        this.Cache.Set(token, result)
+    }
+    return result, err
+}
+
+/**
+ * fetch asks the arc-auth-server about token, bypassing the cache
+ *
+ * When the peer credentials are rejected with a 401 and the CredentialProvider has secondary credentials, the request
+ * is sent once more with them.
+ */
+func (this *ArcAuthClient) fetch(ctx context.Context, token string) (*AuthResult, error) {
+    provider := this.credentials()
+    credentials, err := provider.Credentials(ctx)
+    if err != nil {
+        return nil, err
+    }
+    result, err := this.exchange(ctx, token, credentials)
+
+    var errorResponse *ErrorResponse
+    dual, isDual := provider.(SecondaryCredentialProvider)
+    if !isDual || !errors.As(err, &errorResponse) || errorResponse.Code != http.StatusUnauthorized {
+        return result, err
+    }
+    secondary, found, secondaryErr := dual.SecondaryCredentials(ctx)
+    if secondaryErr != nil || !found || secondary == credentials {
+        return result, err
+    }
+    this.logger().Info("arc-auth rejected the peer credentials, retrying with the secondary ones", "user", secondary.User)
+    result, err = this.exchange(ctx, token, secondary)
+    if err == nil {
+        dual.Accepted(secondary)
+    }
+    return result, err
+}
+
+/**
+ * send makes a single request to the ".../auth" endpoint of the arc-auth-server at host with the given peer credentials
+ *
+ * Every failure is returned as an error, never a panic: a request that got no answer is a *RequestError, an
+ * unexpected status an *ErrorResponse and an unreadable identity a *MalformedResponseError.  The response body is
+ * always drained so that the connection can be reused.
+ */
+func (this *ArcAuthClient) send(ctx context.Context, host, token string, credentials Credentials) (result *AuthResult, err error) {
+    logger := this.logger()
+    masked := this.Mask(token)
+    attempt := attemptFrom(ctx)
+    if this.Limiter != nil && !hasReservedSlot(ctx) {
+        if err := this.Limiter.Wait(ctx); err != nil {
+            logger.Warn("arc-auth request not sent", "token", masked, "error", err)
+            return nil, &RequestError{Token: masked, Attempt: attempt, Err: err}
+        }
+    }
+    request, err := newAuthRequest(ctx, host)
+    if err != nil {
+        err = &RequestError{Token: masked, Attempt: attempt, Kind: ErrInvalidHost, Err: err}
+        logger.Warn("arc-auth request not sent", "token", masked, "error", err)
+        return nil, err
+    }
+    request.SetBasicAuth(credentials.User, credentials.Pass)
+    request.Header.Set(AdmiralTokenHeader, token)
+    if this.UserAgent != "" {
+        request.Header.Set("User-Agent", this.UserAgent)
+    }
+
+    logger.Debug("arc-auth request", "url", request.URL.Redacted(), "token", masked)
+    status := 0
+    if this.Metrics != nil {
+        done := this.Metrics.startRequest()
+        defer func() { done(status) }()
+    }
+    observer := this.observer()
+    observer.OnRequestStart(ctx, ObservedEvent{Token: masked, Attempt: attempt})
+    started := time.Now()
+    defer func() {
+        observer.OnResponse(ctx, ObservedEvent{Token: masked, Attempt: attempt, Status: status, Duration: time.Since(started), Err: err})
+    }()
+    response, err := this.doer().Do(request)
+    if err == nil && response == nil {
+        err = errNoResponse
+    }
+    if err != nil {
+        if response != nil && response.Body != nil {
+            response.Body.Close()
+        }
+        err = newRequestError(ctx, masked, attempt, err)
+        logger.Warn("arc-auth request failed", "token", masked, "error", err)
+        return nil, err
+    }
+    if response.Body == nil {
+        response.Body = http.NoBody
+    }
+    defer drainAndClose(response.Body)
+    status = response.StatusCode
 
     if (response.StatusCode == http.StatusNoContent) {
-        log.Printf("Got response code %s for token %s, so returning empty JSON block", response.StatusCode, this.Mask(token))
-        return "{}", nil
+        logger.Debug("arc-auth token is invalid", "token", masked, "status", response.StatusCode)
+        return InvalidAuthResult(), nil
     }
 
     if (response.StatusCode != http.StatusOK) {
-        log.Printf("Got response code %s when authenticating token %s", response.StatusCode, this.Mask(token))
-        return "", &ErrorResponse{Code: response.StatusCode, Message: "Non-20X response code"}
+        logger.Warn("arc-auth unexpected response", "token", masked, "status", response.StatusCode)
+        return nil, newErrorResponse(response, masked, attempt)
+    }
+
+    body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseBody + 1))
+    if err != nil {
+        err = &RequestError{Token: masked, Attempt: attempt, Kind: ErrTruncatedResponse, Err: contextError(ctx, err)}
+        logger.Warn("arc-auth response body could not be read", "token", masked, "error", err)
+        return nil, err
     }
+    if len(body) > maxResponseBody {
+        err = &MalformedResponseError{Err: fmt.Errorf("body is larger than %d bytes", maxResponseBody), Status: response.StatusCode, Token: masked, Attempt: attempt}
+        logger.Warn("arc-auth response body is malformed", "token", masked, "error", err)
+        return nil, err
+    }
+    result, err = DecodeAuthResult(body)
+    var malformed *MalformedResponseError
+    if errors.As(err, &malformed) {
+        malformed.Status, malformed.Token, malformed.Attempt = response.StatusCode, masked, attempt
+    }
+    if err != nil {
+        logger.Warn("arc-auth response body is malformed", "token", masked, "error", err)
+        return nil, err
+    }
+    logger.Debug("arc-auth token is valid", "token", masked, "status", response.StatusCode)
+    return result, nil
+}
 
-    body, error := ioutil.ReadAll(response.Body)
-    return string(body), error    
+/**
+ * newAuthRequest builds the request to the ".../auth" endpoint under host, which must be an absolute http(s) URL
+ */
+func newAuthRequest(ctx context.Context, host string) (*http.Request, error) {
+    // the messages are logged and may be served by a health check, they never quote the password of the URL
+    request, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/auth", host), nil)
+    if err != nil {
+        var invalid *url.Error
+        if errors.As(err, &invalid) {
+            err = invalid.Err
+        }
+        return nil, fmt.Errorf("arc-auth server URL %q is invalid: %v", redacted(host), err)
+    }
+    if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+        return nil, fmt.Errorf("arc-auth server URL %q must start with http:// or https://", redacted(host))
+    }
+    if request.URL.Host == "" {
+        return nil, fmt.Errorf("arc-auth server URL %q has no host", redacted(host))
+    }
+    return request, nil
 }
 
-type ErrorResponse struct {
-    Code    int     `json:"code"`
-    Message string  `json:"message"`
+/**
+ * drainAndClose reads what is left of body, up to a limit, so that the connection can be reused for the next request
+ */
+func drainAndClose(body io.ReadCloser) {
+    io.Copy(io.Discard, io.LimitReader(body, maxDrainedBody))
+    body.Close()
 }
 
-func (e *ErrorResponse) Error() string {
-    return fmt.Sprintf("HTTP Code %s | %s", e.Code, e.Message)
+func (this *ArcAuthClient) doer() Doer {
+    if this.Doer != nil {
+        return this.Doer
+    }
+    if this.HttpClient == nil {
+        return http.DefaultClient
+    }
+    return this.HttpClient
 }
 
 /**
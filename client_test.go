@@ -61,6 +61,44 @@ func TestClientWhenServerSendsNoContent(t *testing.T) {
     assert.NoError(t, error)
 }
 
+func TestAuthenticateWhenServerSendsGoodResponse(t *testing.T) {
+    testServer := httptest.NewServer(http.HandlerFunc(createHandlerFunc(200, vaughantJSON)))
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+    assert.Equal(t, "vaughant", result.User.Username)
+    assert.Equal(t, vaughantJSON, string(result.Raw))
+}
+
+func TestAuthenticateWhenServerSendsNoContent(t *testing.T) {
+    testServer := httptest.NewServer(http.HandlerFunc(createHandlerFunc(204, "")))
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+    result, err := arcAuthClient.Authenticate("No Such Token")
+
+    assert.NoError(t, err)
+    assert.False(t, result.Valid)
+}
+
+func TestAuthenticateWhenServerSendsMalformedBody(t *testing.T) {
+    testServer := httptest.NewServer(http.HandlerFunc(createHandlerFunc(200, "Hello, client")))
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.Nil(t, result)
+    assert.IsType(t, &MalformedResponseError{}, err)
+}
+
 func createHandlerFunc(responseCode int, responseBody string) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         w.WriteHeader(responseCode)
@@ -0,0 +1,187 @@
+/**
+ * Command arcauth talks to an arc-auth-server from the terminal
+ *
+ * Usage:
+ *
+ *   arcauth check [flags] [token ...]
+ *
+ * check validates each token given as an argument, or read one per line from stdin when there is none (or "-"),
+ * and prints the identity arc-auth-server associates with it.  The connection settings come from the ARC_AUTH_*
+ * environment variables (see arcauth.Config) and can be overridden with flags.
+ *
+ * Exit codes: 0 when every token is valid, 1 when at least one is invalid, 2 when a token could not be checked,
+ * 64 on a usage error.
+ */
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "os/signal"
+    "strings"
+    "text/tabwriter"
+
+    "github.com/WPMedia/arc-auth-go-client"
+)
+
+const (
+    exitValid   = 0
+    exitInvalid = 1
+    exitError   = 2
+    exitUsage   = 64
+)
+
+func main() {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.LookupEnv)
+    stop()
+    os.Exit(code)
+}
+
+/**
+ * run is main without the process around it, lookup reads the environment
+ */
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, lookup func(string) (string, bool)) int {
+    if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+        fmt.Fprintln(stderr, "usage: arcauth check [flags] [token ...]")
+        return exitUsage
+    }
+    if args[0] != "check" {
+        fmt.Fprintf(stderr, "arcauth: unknown command %q, the only command is check\n", args[0])
+        return exitUsage
+    }
+    return check(ctx, args[1:], stdin, stdout, stderr, lookup)
+}
+
+/**
+ * checked is the outcome for one token, as printed in the json and jsonl formats
+ */
+type checked struct {
+    Token    string          `json:"token"`
+    Valid    bool            `json:"valid"`
+    Identity json.RawMessage `json:"identity,omitempty"`
+    Error    string          `json:"error,omitempty"`
+
+    result   *arcauth.AuthResult
+}
+
+func check(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, lookup func(string) (string, bool)) int {
+    config := arcauth.ConfigFromLookup(lookup)
+
+    flags := flag.NewFlagSet("arcauth check", flag.ContinueOnError)
+    flags.SetOutput(stderr)
+    flags.StringVar(&config.Server, "server", config.Server, "root URL of the arc-auth-server (ARC_AUTH_SERVER)")
+    flags.StringVar(&config.User, "user", config.User, "peer user (ARC_AUTH_USER)")
+    flags.StringVar(&config.PassFile, "pass-file", config.PassFile, "file holding the peer password (ARC_AUTH_PASS_FILE), the password itself is only read from ARC_AUTH_PASS")
+    flags.StringVar(&config.Timeout, "timeout", config.Timeout, "request timeout, e.g. 2s (ARC_AUTH_TIMEOUT)")
+    flags.StringVar(&config.BasePath, "base-path", config.BasePath, "API base path (ARC_AUTH_BASE_PATH)")
+    format := flags.String("format", "table", "output format: table, json or jsonl")
+    if err := flags.Parse(args); err != nil {
+        return exitUsage
+    }
+    if *format != "table" && *format != "json" && *format != "jsonl" {
+        fmt.Fprintf(stderr, "arcauth: unknown format %q, use table, json or jsonl\n", *format)
+        return exitUsage
+    }
+    if config.PassFile != "" && flagWasSet(flags, "pass-file") {
+        // an explicit pass file wins over a password from the environment
+        config.Pass = ""
+    }
+
+    client, err := config.NewClient(arcauth.WithUserAgent("arcauth-cli"))
+    if err != nil {
+        fmt.Fprintf(stderr, "arcauth: %v\n", err)
+        return exitUsage
+    }
+
+    tokens := flags.Args()
+    if len(tokens) == 0 || (len(tokens) == 1 && tokens[0] == "-") {
+        if tokens, err = readTokens(stdin); err != nil {
+            fmt.Fprintf(stderr, "arcauth: cannot read tokens from stdin: %v\n", err)
+            return exitError
+        }
+    }
+    if len(tokens) == 0 {
+        fmt.Fprintln(stderr, "arcauth: no token to check")
+        return exitUsage
+    }
+
+    code := exitValid
+    outcomes := make([]checked, 0, len(tokens))
+    for _, token := range tokens {
+        outcome := checked{Token: client.Mask(token)}
+        result, err := client.AuthenticateContext(ctx, token)
+        switch {
+        case err != nil:
+            outcome.Error = err.Error()
+            code = exitError
+        case !result.Valid:
+            if code == exitValid {
+                code = exitInvalid
+            }
+        default:
+            outcome.Valid = true
+            outcome.Identity = result.Raw
+        }
+        outcome.result = result
+        outcomes = append(outcomes, outcome)
+        if *format == "jsonl" {
+            json.NewEncoder(stdout).Encode(outcome)
+        }
+    }
+
+    switch *format {
+    case "json":
+        encoder := json.NewEncoder(stdout)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(outcomes)
+    case "table":
+        writeTable(stdout, outcomes)
+    }
+    return code
+}
+
+func writeTable(w io.Writer, outcomes []checked) {
+    table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(table, "TOKEN\tVALID\tUSER\tEMAIL\tORGANIZATION\tROLES\tERROR")
+    for _, outcome := range outcomes {
+        user, email, organization, roles := "-", "-", "-", "-"
+        if outcome.Valid {
+            result := outcome.result
+            user, email, organization = result.User.Username, result.User.Email, result.Organization.Name
+            roles = strings.Join(result.Roles, ",")
+        }
+        errorText := "-"
+        if outcome.Error != "" {
+            errorText = outcome.Error
+        }
+        fmt.Fprintf(table, "%s\t%v\t%s\t%s\t%s\t%s\t%s\n", outcome.Token, outcome.Valid, user, email, organization, roles, errorText)
+    }
+    table.Flush()
+}
+
+func readTokens(r io.Reader) ([]string, error) {
+    var tokens []string
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        if token := strings.TrimSpace(scanner.Text()); token != "" {
+            tokens = append(tokens, token)
+        }
+    }
+    return tokens, scanner.Err()
+}
+
+func flagWasSet(flags *flag.FlagSet, name string) bool {
+    set := false
+    flags.Visit(func(f *flag.Flag) {
+        if f.Name == name {
+            set = true
+        }
+    })
+    return set
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "strings"
+    "testing"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func envFor(server *arcauthtest.Server) func(string) (string, bool) {
+    env := map[string]string{
+        "ARC_AUTH_SERVER": server.URL,
+        "ARC_AUTH_USER":   server.User(),
+        "ARC_AUTH_PASS":   server.Pass(),
+    }
+    return func(name string) (string, bool) {
+        value, found := env[name]
+        return value, found
+    }
+}
+
+func runCheck(server *arcauthtest.Server, stdin string, args ...string) (int, string, string) {
+    var stdout, stderr bytes.Buffer
+    code := run(context.Background(), append([]string{"check"}, args...), strings.NewReader(stdin), &stdout, &stderr, envFor(server))
+    return code, stdout.String(), stderr.String()
+}
+
+func TestCheckValidTokenTable(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    code, stdout, _ := runCheck(server, "", "FakeDemoToken")
+
+    assert.Equal(t, exitValid, code)
+    assert.Contains(t, stdout, "TOKEN")
+    assert.Contains(t, stdout, "vaughant")
+    assert.Contains(t, stdout, "admin,editor")
+    assert.NotContains(t, stdout, "FakeDemoToken", "tokens are masked")
+}
+
+func TestCheckInvalidTokenJSON(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    code, stdout, _ := runCheck(server, "", "-format", "json", "FakeDemoToken", "No Such Token")
+
+    assert.Equal(t, exitInvalid, code)
+    var outcomes []map[string]interface{}
+    assert.NoError(t, json.Unmarshal([]byte(stdout), &outcomes))
+    assert.Len(t, outcomes, 2)
+    assert.Equal(t, true, outcomes[0]["valid"])
+    assert.Equal(t, "vaughant", outcomes[0]["identity"].(map[string]interface{})["user"].(map[string]interface{})["username"])
+    assert.Equal(t, false, outcomes[1]["valid"])
+}
+
+func TestCheckTokensFromStdinJSONLines(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    code, stdout, _ := runCheck(server, "FakeDemoToken\n\nFakeReaderToken\n", "-format", "jsonl")
+
+    assert.Equal(t, exitValid, code)
+    lines := strings.Split(strings.TrimSpace(stdout), "\n")
+    assert.Len(t, lines, 2)
+    assert.Contains(t, lines[1], `"username":"reader"`)
+}
+
+func TestCheckUpstreamError(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    server.Script(arcauthtest.Burst(503, 1))
+
+    code, stdout, _ := runCheck(server, "", "FakeDemoToken", "No Such Token")
+
+    assert.Equal(t, exitError, code, "an error wins over an invalid token")
+    assert.Contains(t, stdout, "503")
+}
+
+func TestCheckFlagsOverrideEnv(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    other := arcauthtest.NewServer()
+    other.Close()
+
+    code, _, _ := runCheck(other, "", "-server", server.URL, "FakeDemoToken")
+
+    assert.Equal(t, exitValid, code)
+}
+
+func TestCheckUsageErrors(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    code, _, _ := runCheck(server, "", "-format", "xml", "FakeDemoToken")
+    assert.Equal(t, exitUsage, code)
+
+    code, _, stderr := runCheck(server, "")
+    assert.Equal(t, exitUsage, code)
+    assert.Contains(t, stderr, "no token")
+
+    var out bytes.Buffer
+    assert.Equal(t, exitUsage, run(context.Background(), []string{"frobnicate"}, nil, &out, &out, envFor(server)))
+    assert.Equal(t, exitUsage, run(context.Background(), nil, nil, &out, &out, envFor(server)))
+}
+
+func TestCheckMissingConfigNamesSetting(t *testing.T) {
+    var stdout, stderr bytes.Buffer
+    noEnv := func(string) (string, bool) { return "", false }
+
+    code := run(context.Background(), []string{"check", "FakeDemoToken"}, nil, &stdout, &stderr, noEnv)
+
+    assert.Equal(t, exitUsage, code)
+    assert.Contains(t, stderr.String(), "ARC_AUTH_SERVER")
+}
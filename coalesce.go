@@ -0,0 +1,81 @@
+package arcauth
+
+import (
+    "context"
+    "sync"
+)
+
+/**
+ * flightGroup de-duplicates concurrent lookups of the same token so that they share a single outbound request
+ *
+ * The shared request runs on a context detached from the callers' cancellation: a caller that gives up only stops
+ * waiting, the request is canceled once every caller waiting on it has given up.  The zero value is ready to use.
+ */
+type flightGroup struct {
+    mutex sync.Mutex
+    calls map[tokenKey]*flightCall
+}
+
+type flightCall struct {
+    done    chan struct{}
+    cancel  context.CancelFunc
+    waiters int
+    result  *AuthResult
+    err     error
+}
+
+/**
+ * do runs fn once for all the concurrent callers asking about token, shared is true for callers that joined a call
+ * started by someone else
+ */
+func (this *flightGroup) do(ctx context.Context, token string, fn func(context.Context) (*AuthResult, error)) (result *AuthResult, err error, shared bool) {
+    key := keyFor(token)
+
+    this.mutex.Lock()
+    if this.calls == nil {
+        this.calls = make(map[tokenKey]*flightCall)
+    }
+    call, shared := this.calls[key]
+    if !shared {
+        flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+        call = &flightCall{done: make(chan struct{}), cancel: cancel}
+        this.calls[key] = call
+        go this.run(flightCtx, key, call, fn)
+    }
+    call.waiters++
+    this.mutex.Unlock()
+
+    select {
+    case <-call.done:
+        return call.result, call.err, shared
+    case <-ctx.Done():
+        this.mutex.Lock()
+        call.waiters--
+        if call.waiters == 0 {
+            // nobody is interested anymore, later callers must not join a canceled call
+            call.cancel()
+            this.forget(key, call)
+        }
+        this.mutex.Unlock()
+        return nil, contextError(ctx, ctx.Err()), shared
+    }
+}
+
+func (this *flightGroup) run(ctx context.Context, key tokenKey, call *flightCall, fn func(context.Context) (*AuthResult, error)) {
+    defer call.cancel()
+    call.result, call.err = fn(ctx)
+
+    this.mutex.Lock()
+    this.forget(key, call)
+    this.mutex.Unlock()
+    close(call.done)
+}
+
+/**
+ * forget removes call from the in-flight calls, this.mutex must be held
+ */
+func (this *flightGroup) forget(key tokenKey, call *flightCall) {
+    if this.calls[key] == call {
+        delete(this.calls, key)
+    }
+}
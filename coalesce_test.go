@@ -0,0 +1,132 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+/**
+ * createGatedServer answers every request with the vaughant fixture once release is closed
+ */
+func createGatedServer(calls *int32, release chan struct{}) *httptest.Server {
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(calls, 1)
+        select {
+        case <-release:
+        case <-r.Context().Done():
+            return
+        }
+        fmt.Fprint(w, vaughantJSON)
+    }))
+}
+
+func TestCoalesceSharesOneRequest(t *testing.T) {
+    var calls int32
+    release := make(chan struct{})
+    testServer := createGatedServer(&calls, release)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Coalesce = true
+
+    var wait sync.WaitGroup
+    results := make([]*AuthResult, 20)
+    errs := make([]error, 20)
+    for i := range results {
+        wait.Add(1)
+        go func(i int) {
+            defer wait.Done()
+            results[i], errs[i] = arcAuthClient.Authenticate("FakeDemoToken")
+        }(i)
+    }
+    time.Sleep(50 * time.Millisecond)
+    close(release)
+    wait.Wait()
+
+    assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+    for i := range results {
+        assert.NoError(t, errs[i])
+        assert.Equal(t, "vaughant", results[i].User.Username)
+    }
+}
+
+func TestCoalesceDoesNotShareAcrossTokens(t *testing.T) {
+    var calls int32
+    release := make(chan struct{})
+    close(release)
+    testServer := createGatedServer(&calls, release)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Coalesce = true
+
+    arcAuthClient.Authenticate("one")
+    arcAuthClient.Authenticate("two")
+
+    assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestCoalesceCanceledCallerDoesNotCancelOthers(t *testing.T) {
+    var calls int32
+    release := make(chan struct{})
+    testServer := createGatedServer(&calls, release)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Coalesce = true
+
+    ctx, cancel := context.WithCancel(context.Background())
+    canceledErr := make(chan error)
+    go func() {
+        _, err := arcAuthClient.AuthenticateContext(ctx, "FakeDemoToken")
+        canceledErr <- err
+    }()
+    time.Sleep(20 * time.Millisecond)
+
+    patient := make(chan *AuthResult)
+    go func() {
+        result, _ := arcAuthClient.Authenticate("FakeDemoToken")
+        patient <- result
+    }()
+    time.Sleep(20 * time.Millisecond)
+
+    cancel()
+    assert.True(t, errors.Is(<-canceledErr, ErrCanceled))
+
+    close(release)
+    result := <-patient
+    assert.NotNil(t, result)
+    assert.Equal(t, "vaughant", result.User.Username)
+    assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestCoalesceLastCallerLeavingCancelsRequest(t *testing.T) {
+    var calls int32
+    release := make(chan struct{})
+    testServer := createGatedServer(&calls, release)
+    defer testServer.Close()
+    defer close(release)
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Coalesce = true
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    _, err := arcAuthClient.AuthenticateContext(ctx, "FakeDemoToken")
+    assert.True(t, errors.Is(err, ErrDeadlineExceeded))
+
+    // a new caller starts a fresh request rather than joining the abandoned one
+    ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    arcAuthClient.AuthenticateContext(ctx, "FakeDemoToken")
+    assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
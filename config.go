@@ -0,0 +1,321 @@
+package arcauth
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+/**
+ * Config is the serializable configuration of an ArcAuthClient
+ *
+ * In the environment and in KEY=VALUE files each setting is read from the variable listed first below, JSON files
+ * use the key listed second:
+ *
+ *   ARC_AUTH_SERVER           server            root FQDN of the arc-auth-server (required)
+ *   ARC_AUTH_USER             user              peer user for BasicAuth (required, or ARC_AUTH_USER_FILE)
+ *   ARC_AUTH_USER_FILE        user_file         file holding the peer user
+ *   ARC_AUTH_PASS             pass              peer password for BasicAuth (required, or ARC_AUTH_PASS_FILE)
+ *   ARC_AUTH_PASS_FILE        pass_file         file holding the peer password, e.g. a mounted secret
+ *   ARC_AUTH_TIMEOUT          timeout           request timeout as a Go duration, e.g. "2s"
+ *   ARC_AUTH_BASE_PATH        base_path         API base path, "/api/v1" by default
+ *   ARC_AUTH_USER_AGENT       user_agent        User-Agent header
+ *   ARC_AUTH_TLS_CERT_FILE    tls_cert_file     client certificate for mutual TLS (PEM)
+ *   ARC_AUTH_TLS_KEY_FILE     tls_key_file      key of the client certificate (PEM)
+ *   ARC_AUTH_TLS_CA_FILE      tls_ca_file       CA bundle the server certificate must chain to (PEM)
+ *   ARC_AUTH_TLS_PINS         tls_pins          comma separated "sha256/<base64>" SPKI pins
+ *   ARC_AUTH_TLS_MIN_VERSION  tls_min_version   "1.2" (default) or "1.3"
+ *
+ * Secret files are read whole with surrounding whitespace trimmed, and read again when they change so that the peer
+ * credentials can be rotated without a restart.  Error messages name the setting at fault but
+ * never contain its value.
+ */
+type Config struct {
+    Server    string `json:"server"`
+    User      string `json:"user"`
+    UserFile  string `json:"user_file"`
+    Pass      string `json:"pass"`
+    PassFile  string `json:"pass_file"`
+    Timeout   string `json:"timeout"`
+    BasePath  string `json:"base_path"`
+    UserAgent string `json:"user_agent"`
+
+    TLSCertFile   string `json:"tls_cert_file"`
+    TLSKeyFile    string `json:"tls_key_file"`
+    TLSCAFile     string `json:"tls_ca_file"`
+    TLSPins       string `json:"tls_pins"`
+    TLSMinVersion string `json:"tls_min_version"`
+}
+
+/**
+ * ConfigError tells which setting of a Config is wrong
+ */
+type ConfigError struct {
+    Setting string
+    Reason  string
+}
+
+func (e *ConfigError) Error() string {
+    return fmt.Sprintf("arcauth: invalid setting %s: %s", e.Setting, e.Reason)
+}
+
+/**
+ * settings binds each environment variable name to its field
+ */
+func (this *Config) settings() []struct {
+    name  string
+    value *string
+} {
+    return []struct {
+        name  string
+        value *string
+    }{
+        {"ARC_AUTH_SERVER", &this.Server},
+        {"ARC_AUTH_USER", &this.User},
+        {"ARC_AUTH_USER_FILE", &this.UserFile},
+        {"ARC_AUTH_PASS", &this.Pass},
+        {"ARC_AUTH_PASS_FILE", &this.PassFile},
+        {"ARC_AUTH_TIMEOUT", &this.Timeout},
+        {"ARC_AUTH_BASE_PATH", &this.BasePath},
+        {"ARC_AUTH_USER_AGENT", &this.UserAgent},
+        {"ARC_AUTH_TLS_CERT_FILE", &this.TLSCertFile},
+        {"ARC_AUTH_TLS_KEY_FILE", &this.TLSKeyFile},
+        {"ARC_AUTH_TLS_CA_FILE", &this.TLSCAFile},
+        {"ARC_AUTH_TLS_PINS", &this.TLSPins},
+        {"ARC_AUTH_TLS_MIN_VERSION", &this.TLSMinVersion},
+    }
+}
+
+/**
+ * ConfigFromEnv reads a Config from the ARC_AUTH_* environment variables
+ */
+func ConfigFromEnv() *Config {
+    return ConfigFromLookup(os.LookupEnv)
+}
+
+/**
+ * ConfigFromLookup reads a Config from the ARC_AUTH_* variables lookup finds, e.g. in a map instead of the environment
+ */
+func ConfigFromLookup(lookup func(string) (string, bool)) *Config {
+    config := &Config{}
+    for _, setting := range config.settings() {
+        if value, found := lookup(setting.name); found {
+            *setting.value = value
+        }
+    }
+    return config
+}
+
+/**
+ * ConfigFromFile reads a Config from a JSON file (".json" extension or content starting with "{") or from a file
+ * of KEY=VALUE lines using the environment variable names, where blank lines and lines starting with # are ignored
+ */
+func ConfigFromFile(path string) (*Config, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("arcauth: cannot read config file: %v", err)
+    }
+
+    trimmed := bytes.TrimSpace(content)
+    if strings.EqualFold(filepath.Ext(path), ".json") || bytes.HasPrefix(trimmed, []byte("{")) {
+        config := &Config{}
+        decoder := json.NewDecoder(bytes.NewReader(trimmed))
+        decoder.DisallowUnknownFields()
+        if err := decoder.Decode(config); err != nil {
+            // json errors may quote the offending value, only report where it is
+            return nil, fmt.Errorf("arcauth: config file %s is not a valid JSON config: %s", path, jsonErrorLocation(err))
+        }
+        return config, nil
+    }
+
+    values := map[string]string{}
+    scanner := bufio.NewScanner(bytes.NewReader(content))
+    for number := 1; scanner.Scan(); number++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        line = strings.TrimPrefix(line, "export ")
+        key, value, found := strings.Cut(line, "=")
+        if !found {
+            return nil, fmt.Errorf("arcauth: config file %s line %d is not a KEY=VALUE pair", path, number)
+        }
+        values[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("arcauth: cannot read config file: %v", err)
+    }
+
+    config := ConfigFromLookup(func(key string) (string, bool) {
+        value, found := values[key]
+        delete(values, key)
+        return value, found
+    })
+    for key := range values {
+        return nil, &ConfigError{Setting: key, Reason: "unknown setting"}
+    }
+    return config, nil
+}
+
+/**
+ * Options validates the Config and turns it into the arguments of New
+ */
+func (this *Config) Options() (server, user, pass string, options []Option, err error) {
+    if this.Server == "" {
+        return "", "", "", nil, &ConfigError{Setting: "ARC_AUTH_SERVER", Reason: "is required"}
+    }
+    if !strings.HasPrefix(this.Server, "http://") && !strings.HasPrefix(this.Server, "https://") {
+        return "", "", "", nil, &ConfigError{Setting: "ARC_AUTH_SERVER", Reason: "must start with http:// or https://"}
+    }
+    user, err = secret("ARC_AUTH_USER", this.User, this.UserFile)
+    if err != nil {
+        return "", "", "", nil, err
+    }
+    pass, err = secret("ARC_AUTH_PASS", this.Pass, this.PassFile)
+    if err != nil {
+        return "", "", "", nil, err
+    }
+
+    if this.Timeout != "" {
+        timeout, err := time.ParseDuration(this.Timeout)
+        if err != nil || timeout < 0 {
+            return "", "", "", nil, &ConfigError{Setting: "ARC_AUTH_TIMEOUT", Reason: "must be a positive duration like \"2s\""}
+        }
+        options = append(options, WithTimeout(timeout))
+    }
+    if this.BasePath != "" {
+        if !strings.HasPrefix(this.BasePath, "/") {
+            return "", "", "", nil, &ConfigError{Setting: "ARC_AUTH_BASE_PATH", Reason: "must start with '/'"}
+        }
+        options = append(options, WithBasePath(this.BasePath))
+    }
+    if this.UserAgent != "" {
+        if strings.ContainsAny(this.UserAgent, "\r\n") {
+            return "", "", "", nil, &ConfigError{Setting: "ARC_AUTH_USER_AGENT", Reason: "must be a single line"}
+        }
+        options = append(options, WithUserAgent(this.UserAgent))
+    }
+    if tlsOption, err := this.tlsOption(); err != nil {
+        return "", "", "", nil, err
+    } else if tlsOption != nil {
+        options = append(options, tlsOption)
+    }
+    return this.Server, user, pass, options, nil
+}
+
+func (this *Config) tlsOption() (Option, error) {
+    if this.TLSCertFile == "" && this.TLSKeyFile == "" && this.TLSCAFile == "" && this.TLSPins == "" && this.TLSMinVersion == "" {
+        return nil, nil
+    }
+    settings := TLSSettings{CertFile: this.TLSCertFile, KeyFile: this.TLSKeyFile, CAFile: this.TLSCAFile}
+    for _, pin := range strings.Split(this.TLSPins, ",") {
+        if pin = strings.TrimSpace(pin); pin != "" {
+            settings.Pins = append(settings.Pins, pin)
+        }
+    }
+    switch this.TLSMinVersion {
+    case "", "1.2":
+        settings.MinVersion = tls.VersionTLS12
+    case "1.3":
+        settings.MinVersion = tls.VersionTLS13
+    default:
+        return nil, &ConfigError{Setting: "ARC_AUTH_TLS_MIN_VERSION", Reason: "must be \"1.2\" or \"1.3\""}
+    }
+    if (settings.CertFile == "") != (settings.KeyFile == "") {
+        return nil, &ConfigError{Setting: "ARC_AUTH_TLS_CERT_FILE", Reason: "must be set together with ARC_AUTH_TLS_KEY_FILE"}
+    }
+    if _, err := NewTLSConfig(settings); err != nil {
+        return nil, &ConfigError{Setting: "ARC_AUTH_TLS_*", Reason: err.Error()}
+    }
+    return WithTLS(settings), nil
+}
+
+/**
+ * NewClient constructs an ArcAuthClient from the Config, options are applied after the ones of the Config
+ */
+func (this *Config) NewClient(options ...Option) (*ArcAuthClient, error) {
+    server, user, pass, configOptions, err := this.Options()
+    if err != nil {
+        return nil, err
+    }
+    if this.PassFile != "" {
+        provider := &FileCredentials{User: this.User, UserFile: this.UserFile, PassFile: this.PassFile}
+        configOptions = append(configOptions, WithCredentials(provider))
+    }
+    return New(server, user, pass, append(configOptions, options...)...)
+}
+
+/**
+ * NewFromEnv constructs an ArcAuthClient configured by the ARC_AUTH_* environment variables, see Config
+ */
+func NewFromEnv(options ...Option) (*ArcAuthClient, error) {
+    return ConfigFromEnv().NewClient(options...)
+}
+
+/**
+ * NewFromConfigFile constructs an ArcAuthClient configured by the JSON or KEY=VALUE file at path, see Config
+ */
+func NewFromConfigFile(path string, options ...Option) (*ArcAuthClient, error) {
+    config, err := ConfigFromFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return config.NewClient(options...)
+}
+
+/**
+ * secret returns the inline value or the content of file, exactly one of them must be set
+ */
+func secret(setting, value, file string) (string, error) {
+    if value != "" && file != "" {
+        return "", &ConfigError{Setting: setting, Reason: "cannot be set together with " + setting + "_FILE"}
+    }
+    if file != "" {
+        content, err := ioutil.ReadFile(file)
+        if err != nil {
+            return "", &ConfigError{Setting: setting + "_FILE", Reason: "cannot be read: " + describeFileError(err)}
+        }
+        value = strings.TrimSpace(string(content))
+        if value == "" {
+            return "", &ConfigError{Setting: setting + "_FILE", Reason: "file is empty"}
+        }
+    }
+    if value == "" {
+        return "", &ConfigError{Setting: setting, Reason: "is required (or " + setting + "_FILE)"}
+    }
+    return value, nil
+}
+
+func describeFileError(err error) string {
+    if pathError, ok := err.(*os.PathError); ok {
+        return pathError.Err.Error()
+    }
+    return err.Error()
+}
+
+func jsonErrorLocation(err error) string {
+    switch typed := err.(type) {
+    case *json.SyntaxError:
+        return fmt.Sprintf("syntax error at offset %d", typed.Offset)
+    case *json.UnmarshalTypeError:
+        return fmt.Sprintf("field %s must be a %s", typed.Field, typed.Type)
+    }
+    if strings.HasPrefix(err.Error(), "json: unknown field") {
+        return err.Error()
+    }
+    return "cannot be decoded"
+}
+
+func unquote(value string) string {
+    if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value) - 1] == value[0] {
+        return value[1:len(value) - 1]
+    }
+    return value
+}
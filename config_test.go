@@ -0,0 +1,120 @@
+package arcauth
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+    path := filepath.Join(t.TempDir(), name)
+    if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+        t.Fatalf("cannot write %s: %v", path, err)
+    }
+    return path
+}
+
+func TestNewFromEnv(t *testing.T) {
+    t.Setenv("ARC_AUTH_SERVER", "https://arc-auth.example.com")
+    t.Setenv("ARC_AUTH_USER", "demo-app")
+    t.Setenv("ARC_AUTH_PASS_FILE", writeFile(t, "pass", secretPass + "\n"))
+    t.Setenv("ARC_AUTH_TIMEOUT", "3s")
+
+    arcAuthClient, err := NewFromEnv()
+
+    assert.NoError(t, err)
+    assert.Equal(t, "https://arc-auth.example.com/api/v1", arcAuthClient.Host)
+    assert.Equal(t, "demo-app", arcAuthClient.User)
+    assert.Equal(t, secretPass, arcAuthClient.Pass)
+    assert.Equal(t, 3 * time.Second, arcAuthClient.HttpClient.Timeout)
+}
+
+func TestNewFromEnvNamesMissingSetting(t *testing.T) {
+    t.Setenv("ARC_AUTH_SERVER", "https://arc-auth.example.com")
+    t.Setenv("ARC_AUTH_USER", "demo-app")
+    os.Unsetenv("ARC_AUTH_PASS")
+    os.Unsetenv("ARC_AUTH_PASS_FILE")
+
+    _, err := NewFromEnv()
+
+    configError, ok := err.(*ConfigError)
+    assert.True(t, ok, "expected a *ConfigError but got %v", err)
+    assert.Equal(t, "ARC_AUTH_PASS", configError.Setting)
+}
+
+func TestNewFromConfigFileJSON(t *testing.T) {
+    passFile := writeFile(t, "pass", secretPass)
+    path := writeFile(t, "arcauth.json", `{
+        "server": "https://arc-auth.example.com",
+        "user": "demo-app",
+        "pass_file": "` + passFile + `",
+        "base_path": "/api/v2",
+        "user_agent": "stories-api/1.0"
+    }`)
+
+    arcAuthClient, err := NewFromConfigFile(path)
+
+    assert.NoError(t, err)
+    assert.Equal(t, "https://arc-auth.example.com/api/v2", arcAuthClient.Host)
+    assert.Equal(t, secretPass, arcAuthClient.Pass)
+    assert.Equal(t, "stories-api/1.0", arcAuthClient.UserAgent)
+}
+
+func TestNewFromConfigFileKeyValue(t *testing.T) {
+    path := writeFile(t, "arcauth.env", `
+# arc-auth peer settings
+ARC_AUTH_SERVER=https://arc-auth.example.com
+export ARC_AUTH_USER="demo-app"
+ARC_AUTH_PASS='` + secretPass + `'
+ARC_AUTH_TIMEOUT=500ms
+`)
+
+    arcAuthClient, err := NewFromConfigFile(path)
+
+    assert.NoError(t, err)
+    assert.Equal(t, "demo-app", arcAuthClient.User)
+    assert.Equal(t, secretPass, arcAuthClient.Pass)
+    assert.Equal(t, 500 * time.Millisecond, arcAuthClient.HttpClient.Timeout)
+}
+
+func TestConfigErrorsNeverEchoSecrets(t *testing.T) {
+    files := map[string]string{
+        "both.env":    "ARC_AUTH_SERVER=https://a.example.com\nARC_AUTH_USER=u\nARC_AUTH_PASS=" + secretPass + "\nARC_AUTH_PASS_FILE=/nowhere",
+        "missing.env": "ARC_AUTH_SERVER=https://a.example.com\nARC_AUTH_USER=u\nARC_AUTH_PASS_FILE=/nowhere/" + secretPass,
+        "timeout.env": "ARC_AUTH_SERVER=https://a.example.com\nARC_AUTH_USER=u\nARC_AUTH_PASS=" + secretPass + "\nARC_AUTH_TIMEOUT=" + secretPass,
+        "unknown.env": "ARC_AUTH_SERVER=https://a.example.com\nARC_AUTH_PASSWORD=" + secretPass,
+        "bad.json":    `{"server": "https://a.example.com", "pass": ["` + secretPass + `"]}`,
+        "broken.json": `{"server": "https://a.example.com", "pass": "` + secretPass + `"`,
+    }
+    expectedSettings := map[string]string{
+        "both.env":    "ARC_AUTH_PASS",
+        "missing.env": "ARC_AUTH_PASS_FILE",
+        "timeout.env": "ARC_AUTH_TIMEOUT",
+        "unknown.env": "ARC_AUTH_PASSWORD",
+        "bad.json":    "pass",
+    }
+    for name, content := range files {
+        _, err := NewFromConfigFile(writeFile(t, name, content))
+
+        assert.Error(t, err, name)
+        if err != nil {
+            assert.NotContains(t, err.Error(), secretPass, name)
+            if setting, found := expectedSettings[name]; found {
+                assert.True(t, strings.Contains(err.Error(), setting), "%s: %v should name %s", name, err, setting)
+            }
+        }
+    }
+}
+
+func TestConfigRequiresServerScheme(t *testing.T) {
+    config := &Config{Server: "arc-auth.example.com", User: "u", Pass: "p"}
+
+    _, err := config.NewClient()
+
+    assert.Equal(t, "ARC_AUTH_SERVER", err.(*ConfigError).Setting)
+}
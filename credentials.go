@@ -0,0 +1,204 @@
+package arcauth
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/**
+ * Credentials are the BasicAuth user and password the client authenticates itself with as an arc-auth-server peer
+ */
+type Credentials struct {
+    User string
+    Pass string
+}
+
+/**
+ * CredentialProvider hands out the peer Credentials, ArcAuthClient asks it before every request so that the
+ * credentials can be rotated without restarting
+ */
+type CredentialProvider interface {
+    Credentials(ctx context.Context) (Credentials, error)
+}
+
+/**
+ * SecondaryCredentialProvider is implemented by providers holding a second set of Credentials, the client retries
+ * a request once with them when the arc-auth-server rejects the first ones with a 401
+ *
+ * Accepted is called with the credentials that were accepted after such a retry.
+ */
+type SecondaryCredentialProvider interface {
+    CredentialProvider
+    SecondaryCredentials(ctx context.Context) (Credentials, bool, error)
+    Accepted(credentials Credentials)
+}
+
+/**
+ * StaticCredentials never change, it is what the client uses for its User and Pass when no provider is set
+ */
+type StaticCredentials Credentials
+
+func (this StaticCredentials) Credentials(ctx context.Context) (Credentials, error) {
+    return Credentials(this), nil
+}
+
+/**
+ * EnvCredentials reads the credentials from environment variables on every request
+ */
+type EnvCredentials struct {
+    UserVar string
+    PassVar string
+}
+
+func (this EnvCredentials) Credentials(ctx context.Context) (Credentials, error) {
+    credentials := Credentials{User: os.Getenv(this.UserVar), Pass: os.Getenv(this.PassVar)}
+    if credentials.User == "" || credentials.Pass == "" {
+        return Credentials{}, fmt.Errorf("arcauth: environment variables %s and %s must both be set", this.UserVar, this.PassVar)
+    }
+    return credentials, nil
+}
+
+/**
+ * FileCredentials reads the password (and optionally the user) from files, e.g. mounted secrets, and reloads them
+ * when the files change
+ *
+ * User is used when UserFile is empty.  When a changed file cannot be read the last good credentials are kept.
+ */
+type FileCredentials struct {
+    User     string
+    UserFile string
+    PassFile string
+
+    mutex       sync.Mutex
+    credentials Credentials
+    modified    time.Time
+}
+
+/**
+ * NewFileCredentials constructs FileCredentials for user and the password in passFile and checks it can be read
+ */
+func NewFileCredentials(user, passFile string) (*FileCredentials, error) {
+    provider := &FileCredentials{User: user, PassFile: passFile}
+    if _, err := provider.Credentials(context.Background()); err != nil {
+        return nil, err
+    }
+    return provider, nil
+}
+
+func (this *FileCredentials) Credentials(ctx context.Context) (Credentials, error) {
+    files := []string{this.PassFile}
+    if this.UserFile != "" {
+        files = append(files, this.UserFile)
+    }
+    modified, statErr := latestModification(files...)
+
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    if statErr == nil && this.credentials.Pass != "" && modified.Equal(this.modified) {
+        return this.credentials, nil
+    }
+
+    credentials, err := this.read()
+    if statErr != nil {
+        err = statErr
+    }
+    if err != nil {
+        if this.credentials.Pass != "" {
+            return this.credentials, nil
+        }
+        return Credentials{}, fmt.Errorf("arcauth: cannot read credential files: %s", describeFileError(err))
+    }
+    this.credentials = credentials
+    this.modified = modified
+    return credentials, nil
+}
+
+func (this *FileCredentials) read() (Credentials, error) {
+    credentials := Credentials{User: this.User}
+    if this.UserFile != "" {
+        content, err := ioutil.ReadFile(this.UserFile)
+        if err != nil {
+            return Credentials{}, err
+        }
+        credentials.User = strings.TrimSpace(string(content))
+    }
+    content, err := ioutil.ReadFile(this.PassFile)
+    if err != nil {
+        return Credentials{}, err
+    }
+    credentials.Pass = strings.TrimSpace(string(content))
+    if credentials.User == "" || credentials.Pass == "" {
+        return Credentials{}, fmt.Errorf("empty user or password")
+    }
+    return credentials, nil
+}
+
+/**
+ * DualCredentials holds the current and the next (or previous) credentials during a rotation
+ *
+ * Requests use the preferred credentials and are retried once with the other ones on a 401.  When the other ones are
+ * accepted they become the preferred ones, so once arc-auth-server switched over only the first request pays for the
+ * retry.  Register the new password on the server, deploy it as Secondary, then retire the old one.
+ */
+type DualCredentials struct {
+    Primary   CredentialProvider
+    Secondary CredentialProvider
+
+    preferSecondary int32
+}
+
+func (this *DualCredentials) Credentials(ctx context.Context) (Credentials, error) {
+    return this.preferred().Credentials(ctx)
+}
+
+func (this *DualCredentials) SecondaryCredentials(ctx context.Context) (Credentials, bool, error) {
+    other := this.other()
+    if other == nil {
+        return Credentials{}, false, nil
+    }
+    credentials, err := other.Credentials(ctx)
+    return credentials, err == nil, err
+}
+
+func (this *DualCredentials) Accepted(credentials Credentials) {
+    // prefer whichever provider holds the accepted credentials, storing rather than flipping keeps it idempotent
+    if this.Secondary != nil {
+        if secondary, err := this.Secondary.Credentials(context.Background()); err == nil && secondary == credentials {
+            atomic.StoreInt32(&this.preferSecondary, 1)
+            return
+        }
+    }
+    if primary, err := this.Primary.Credentials(context.Background()); err == nil && primary == credentials {
+        atomic.StoreInt32(&this.preferSecondary, 0)
+    }
+}
+
+func (this *DualCredentials) preferred() CredentialProvider {
+    if atomic.LoadInt32(&this.preferSecondary) == 1 && this.Secondary != nil {
+        return this.Secondary
+    }
+    return this.Primary
+}
+
+func (this *DualCredentials) other() CredentialProvider {
+    if atomic.LoadInt32(&this.preferSecondary) == 1 {
+        return this.Primary
+    }
+    return this.Secondary
+}
+
+/**
+ * credentials returns the provider the client uses, its own User and Pass when none is set
+ */
+func (this *ArcAuthClient) credentials() CredentialProvider {
+    if this.Credentials == nil {
+        return StaticCredentials{User: this.User, Pass: this.Pass}
+    }
+    return this.Credentials
+}
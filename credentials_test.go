@@ -0,0 +1,129 @@
+package arcauth
+
+import (
+    "context"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestStaticCredentialsByDefault(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+
+    arcAuthClient, _ := New(server.URL, arcauthtest.DemoUser, arcauthtest.DemoPass)
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+}
+
+func TestEnvCredentialsAreReadOnEveryRequest(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    t.Setenv("TEST_ARC_AUTH_USER", arcauthtest.DemoUser)
+    t.Setenv("TEST_ARC_AUTH_PASS", arcauthtest.DemoPass)
+
+    arcAuthClient, _ := New(server.URL, "unused", "unused", WithCredentials(EnvCredentials{UserVar: "TEST_ARC_AUTH_USER", PassVar: "TEST_ARC_AUTH_PASS"}))
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+
+    server.SetPeer(arcauthtest.DemoUser, "rotated")
+    os.Setenv("TEST_ARC_AUTH_PASS", "rotated")
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+
+    os.Unsetenv("TEST_ARC_AUTH_PASS")
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+    assert.Error(t, err)
+}
+
+func TestFileCredentialsReloadOnChange(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    passFile := writeFile(t, "pass", arcauthtest.DemoPass + "\n")
+
+    provider, err := NewFileCredentials(arcauthtest.DemoUser, passFile)
+    assert.NoError(t, err)
+    arcAuthClient, _ := New(server.URL, "unused", "unused", WithCredentials(provider))
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+
+    server.SetPeer(arcauthtest.DemoUser, "rotated")
+    writeAtomically(t, passFile, []byte("rotated\n"))
+    future := time.Now().Add(time.Second)
+    os.Chtimes(passFile, future, future)
+
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+}
+
+func TestFileCredentialsKeepLastGoodOnReadError(t *testing.T) {
+    passFile := writeFile(t, "pass", "secret")
+    provider, _ := NewFileCredentials("user", passFile)
+
+    os.Remove(passFile)
+    credentials, err := provider.Credentials(context.Background())
+
+    assert.NoError(t, err)
+    assert.Equal(t, "secret", credentials.Pass)
+
+    _, err = NewFileCredentials("user", passFile)
+    assert.Error(t, err)
+    assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestDualCredentialsRetryOnceOn401(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    dual := &DualCredentials{
+        Primary:   StaticCredentials{User: arcauthtest.DemoUser, Pass: "old"},
+        Secondary: StaticCredentials{User: arcauthtest.DemoUser, Pass: arcauthtest.DemoPass},
+    }
+    arcAuthClient, _ := New(server.URL, "unused", "unused", WithCredentials(dual))
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+    assert.Equal(t, 2, server.Requests(), "the primary was rejected, then the secondary accepted")
+
+    arcAuthClient.Authenticate("FakeDemoToken")
+    assert.Equal(t, 3, server.Requests(), "the accepted secondary is now used first")
+
+    // rotating back works the same way
+    server.SetPeer(arcauthtest.DemoUser, "old")
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+    assert.Equal(t, 5, server.Requests())
+}
+
+func TestDualCredentialsBothRejected(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    dual := &DualCredentials{
+        Primary:   StaticCredentials{User: "someone", Pass: "old"},
+        Secondary: StaticCredentials{User: "someone", Pass: "older"},
+    }
+    arcAuthClient, _ := New(server.URL, "unused", "unused", WithCredentials(dual))
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    errorResponse, ok := err.(*ErrorResponse)
+    assert.True(t, ok, "expected an *ErrorResponse but got %v", err)
+    if ok {
+        assert.Equal(t, 401, errorResponse.Code)
+    }
+    assert.Equal(t, 2, server.Requests(), "the secondary is only tried once")
+}
+
+func TestConfigPassFileUsesFileCredentials(t *testing.T) {
+    config := &Config{Server: "https://a.example.com", User: "u", PassFile: writeFile(t, "pass", "p")}
+
+    arcAuthClient, err := config.NewClient()
+
+    assert.NoError(t, err)
+    assert.IsType(t, &FileCredentials{}, arcAuthClient.Credentials)
+}
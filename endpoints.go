@@ -0,0 +1,340 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // DefaultEjectionThreshold is the number of consecutive failures after which an endpoint is ejected
+    DefaultEjectionThreshold = 3
+    // DefaultProbeInterval is how often an ejected endpoint is probed, it also bounds a single probe
+    DefaultProbeInterval = 5 * time.Second
+)
+
+// errNoEndpoints is the cause of the ErrInvalidHost failure of a request through an EndpointPool without endpoints
+var errNoEndpoints = errors.New("arc-auth endpoint pool has no endpoints")
+
+/**
+ * Balancing is how an EndpointPool spreads requests over its healthy endpoints
+ */
+type Balancing int
+
+const (
+    // RoundRobin sends each request to the next healthy endpoint in turn
+    RoundRobin Balancing = iota
+    // LeastLatency sends each request to the healthy endpoint with the lowest average response time
+    LeastLatency
+)
+
+func (this Balancing) String() string {
+    switch this {
+    case RoundRobin:
+        return "round-robin"
+    case LeastLatency:
+        return "least-latency"
+    }
+    return "unknown"
+}
+
+/**
+ * EndpointPool lets ArcAuthClient talk to several arc-auth-servers, e.g. one per region
+ *
+ * Each request goes to the healthy endpoint picked by Balancing.  When it fails with a network error or a 5xx the
+ * request is sent to the next healthy endpoint, so failover is invisible to the caller.  An endpoint that fails
+ * EjectionThreshold times in a row is ejected: it no longer gets requests and is probed every ProbeInterval until it
+ * answers again.  Probes are started by the client's traffic, there is no goroutine to stop.  When every endpoint is
+ * ejected the requests are sent to all of them anyway rather than failing without trying.
+ *
+ * OnHealthChange is optional and called, outside of the pool's lock, when an endpoint is ejected or readmitted.
+ */
+type EndpointPool struct {
+    Balancing         Balancing
+    EjectionThreshold int
+    ProbeInterval     time.Duration
+    OnHealthChange    func(host string, healthy bool)
+
+    mutex             sync.Mutex
+    endpoints         []*endpoint
+    next              uint64
+    now               func() time.Time
+}
+
+/**
+ * EndpointStatus describes an endpoint of an EndpointPool
+ */
+type EndpointStatus struct {
+    Host     string
+    Healthy  bool
+    // Failures is the number of consecutive failures
+    Failures int
+    // Latency is the moving average of the response time, zero until the endpoint answered once
+    Latency  time.Duration
+}
+
+type endpoint struct {
+    host      string
+    healthy   bool
+    failures  int
+    latency   time.Duration
+    probing   bool
+    lastProbe time.Time
+}
+
+/**
+ * NewEndpointPool constructs a pool whose endpoints are all healthy, a request through a pool without any fails with
+ * ErrInvalidHost
+ * balancing - how requests are spread over the endpoints
+ * hosts - the arc-auth-server API URLs, base path included (e.g. https://arc-auth.us-east.example.com/api/v1)
+ */
+func NewEndpointPool(balancing Balancing, hosts ...string) *EndpointPool {
+    pool := &EndpointPool{
+        Balancing:         balancing,
+        EjectionThreshold: DefaultEjectionThreshold,
+        ProbeInterval:     DefaultProbeInterval,
+        now:               time.Now,
+    }
+    for _, host := range hosts {
+        pool.endpoints = append(pool.endpoints, &endpoint{host: host, healthy: true})
+    }
+    return pool
+}
+
+/**
+ * Status returns the state of every endpoint, in the order they were given
+ */
+func (this *EndpointPool) Status() []EndpointStatus {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    status := make([]EndpointStatus, 0, len(this.endpoints))
+    for _, endpoint := range this.endpoints {
+        status = append(status, EndpointStatus{
+            Host:     endpoint.host,
+            Healthy:  endpoint.healthy,
+            Failures: endpoint.failures,
+            Latency:  endpoint.latency,
+        })
+    }
+    return status
+}
+
+/**
+ * order returns the endpoints to try for a request, best first, and starts the probes of ejected endpoints that are due
+ */
+func (this *EndpointPool) order(probe func(ctx context.Context, host string) error) []*endpoint {
+    this.mutex.Lock()
+    now := this.clock()
+    var healthy, ejected []*endpoint
+    for _, endpoint := range this.endpoints {
+        if endpoint.healthy {
+            healthy = append(healthy, endpoint)
+            continue
+        }
+        ejected = append(ejected, endpoint)
+        if !endpoint.probing && !now.Before(endpoint.lastProbe.Add(this.probeInterval())) {
+            endpoint.probing = true
+            endpoint.lastProbe = now
+            go this.probe(endpoint, probe)
+        }
+    }
+    candidates := healthy
+    if len(candidates) == 0 {
+        candidates = ejected
+    }
+    candidates = rotate(candidates, int(atomic.AddUint64(&this.next, 1) - 1))
+    if this.Balancing == LeastLatency {
+        // endpoints that never answered have no latency yet and come first, so that they get measured
+        sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].latency < candidates[j].latency })
+    }
+    this.mutex.Unlock()
+    return candidates
+}
+
+/**
+ * report records the outcome of a request sent to endpoint
+ */
+func (this *EndpointPool) report(endpoint *endpoint, latency time.Duration, err error) {
+    if errors.Is(err, ErrCanceled) || errors.Is(err, ErrDeadlineExceeded) || errors.Is(err, ErrRateLimited) {
+        return
+    }
+    this.mutex.Lock()
+    ejected := false
+    if isBreakerFailure(err) {
+        endpoint.failures++
+        if endpoint.healthy && endpoint.failures >= this.ejectionThreshold() {
+            endpoint.healthy = false
+            endpoint.lastProbe = this.clock()
+            ejected = true
+        }
+    } else {
+        endpoint.failures = 0
+        if endpoint.latency == 0 {
+            endpoint.latency = latency
+        } else {
+            endpoint.latency = (4 * endpoint.latency + latency) / 5
+        }
+    }
+    this.mutex.Unlock()
+    if ejected {
+        this.notify(endpoint.host, false)
+    }
+}
+
+func (this *EndpointPool) probe(endpoint *endpoint, probe func(ctx context.Context, host string) error) {
+    ctx, cancel := context.WithTimeout(context.Background(), this.probeInterval())
+    err := probe(ctx, endpoint.host)
+    cancel()
+
+    this.mutex.Lock()
+    endpoint.probing = false
+    readmitted := err == nil && !endpoint.healthy
+    if readmitted {
+        endpoint.healthy = true
+        endpoint.failures = 0
+    }
+    this.mutex.Unlock()
+    if readmitted {
+        this.notify(endpoint.host, true)
+    }
+}
+
+func (this *EndpointPool) notify(host string, healthy bool) {
+    if this.OnHealthChange != nil {
+        this.OnHealthChange(host, healthy)
+    }
+}
+
+func (this *EndpointPool) ejectionThreshold() int {
+    if this.EjectionThreshold < 1 {
+        return 1
+    }
+    return this.EjectionThreshold
+}
+
+func (this *EndpointPool) probeInterval() time.Duration {
+    if this.ProbeInterval <= 0 {
+        return DefaultProbeInterval
+    }
+    return this.ProbeInterval
+}
+
+func (this *EndpointPool) clock() time.Time {
+    if this.now == nil {
+        return time.Now()
+    }
+    return this.now()
+}
+
+func rotate(endpoints []*endpoint, by int) []*endpoint {
+    rotated := make([]*endpoint, 0, len(endpoints))
+    for i := range endpoints {
+        rotated = append(rotated, endpoints[(by + i) % len(endpoints)])
+    }
+    return rotated
+}
+
+/**
+ * redacted returns host with the password of its user info, if any, replaced so that it can be logged
+ */
+func redacted(host string) string {
+    parsed, err := url.Parse(host)
+    if err == nil && parsed.Opaque == "" {
+        return parsed.Redacted()
+    }
+    // a URL that does not parse, or without "//" like "user:pass@host", may still hold a password, drop everything
+    // from the scheme to the last '@'
+    authority := strings.Index(host, "://") + len("://")
+    if authority < len("://") {
+        authority = 0
+    }
+    if at := strings.LastIndex(host, "@"); at >= authority {
+        return host[:authority] + "xxxxx@" + host[at + 1:]
+    }
+    return host
+}
+
+/**
+ * exchange sends a request to the endpoints in turn until one answers, or to Host when there is no EndpointPool
+ */
+func (this *ArcAuthClient) exchange(ctx context.Context, token string, credentials Credentials) (*AuthResult, error) {
+    targets := []*endpoint{{host: this.Host}}
+    if this.Endpoints != nil {
+        targets = this.Endpoints.order(this.probe)
+    }
+    if len(targets) == 0 {
+        err := &RequestError{Token: this.Mask(token), Attempt: attemptFrom(ctx), Kind: ErrInvalidHost, Err: errNoEndpoints}
+        this.logger().Warn("arc-auth request not sent", "token", this.Mask(token), "error", err)
+        return nil, err
+    }
+    if this.Hedge != nil {
+        return this.hedged(ctx, targets, token, credentials)
+    }
+    var result *AuthResult
+    var err error
+    for i, target := range targets {
+        if i > 0 {
+            this.logger().Info("arc-auth failing over", "host", redacted(target.host), "token", this.Mask(token), "error", err)
+        }
+        result, err = this.sendTo(ctx, target, token, credentials)
+        if !isBreakerFailure(err) {
+            return result, err
+        }
+    }
+    return result, err
+}
+
+/**
+ * sendTo sends a request to target and reports its outcome to the EndpointPool and the HedgePolicy
+ */
+func (this *ArcAuthClient) sendTo(ctx context.Context, target *endpoint, token string, credentials Credentials) (*AuthResult, error) {
+    started := time.Now()
+    result, err := this.send(ctx, target.host, token, credentials)
+    latency := time.Since(started)
+    if this.Endpoints != nil {
+        this.Endpoints.report(target, latency, err)
+    }
+    if this.Hedge != nil && err == nil {
+        this.Hedge.record(latency)
+    }
+    return result, err
+}
+
+/**
+ * probe checks that the arc-auth-server at host answers, any answer but a 5xx will do
+ */
+func (this *ArcAuthClient) probe(ctx context.Context, host string) error {
+    credentials, err := this.credentials().Credentials(ctx)
+    if err != nil {
+        return err
+    }
+    request, err := newAuthRequest(ctx, host)
+    if err != nil {
+        return &RequestError{Kind: ErrInvalidHost, Err: err}
+    }
+    request.SetBasicAuth(credentials.User, credentials.Pass)
+    if this.UserAgent != "" {
+        request.Header.Set("User-Agent", this.UserAgent)
+    }
+    response, err := this.doer().Do(request)
+    if err == nil && response == nil {
+        err = errNoResponse
+    }
+    if err != nil {
+        return newRequestError(ctx, "", 0, err)
+    }
+    if response.Body == nil {
+        response.Body = http.NoBody
+    }
+    defer drainAndClose(response.Body)
+    if response.StatusCode >= 500 {
+        return newErrorResponse(response, "", 0)
+    }
+    return nil
+}
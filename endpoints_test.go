@@ -0,0 +1,200 @@
+package arcauth
+
+import (
+    "errors"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func createFailoverClient(t *testing.T, balancing Balancing, servers ...*arcauthtest.Server) *ArcAuthClient {
+    var others []string
+    for _, server := range servers[1:] {
+        others = append(others, server.URL)
+    }
+    arcAuthClient, err := New(servers[0].URL, servers[0].User(), servers[0].Pass(), WithEndpoints(others...), WithBalancing(balancing))
+    assert.NoError(t, err)
+    return arcAuthClient
+}
+
+func TestRoundRobinSpreadsRequests(t *testing.T) {
+    east, west := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer east.Close()
+    defer west.Close()
+    arcAuthClient := createFailoverClient(t, RoundRobin, east, west)
+
+    for i := 0; i < 10; i++ {
+        result, err := arcAuthClient.Authenticate("FakeDemoToken")
+        assert.NoError(t, err)
+        assert.True(t, result.Valid)
+    }
+
+    assert.Equal(t, 5, east.Requests())
+    assert.Equal(t, 5, west.Requests())
+}
+
+func TestFailoverIsTransparent(t *testing.T) {
+    east, west := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer west.Close()
+    arcAuthClient := createFailoverClient(t, RoundRobin, east, west)
+    east.Close()
+
+    for i := 0; i < 4; i++ {
+        result, err := arcAuthClient.Authenticate("FakeDemoToken")
+        assert.NoError(t, err)
+        assert.Equal(t, "vaughant", result.User.Username)
+    }
+    assert.Equal(t, 4, west.Requests())
+}
+
+func TestFailoverOn5xx(t *testing.T) {
+    east, west := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer east.Close()
+    defer west.Close()
+    arcAuthClient := createFailoverClient(t, RoundRobin, east, west)
+    east.Script(arcauthtest.Burst(503, 1))
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+    assert.Equal(t, 1, east.Requests())
+    assert.Equal(t, 1, west.Requests())
+}
+
+func TestNoFailoverOnInvalidToken(t *testing.T) {
+    east, west := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer east.Close()
+    defer west.Close()
+    arcAuthClient := createFailoverClient(t, RoundRobin, east, west)
+
+    result, err := arcAuthClient.Authenticate("No Such Token")
+
+    assert.NoError(t, err)
+    assert.False(t, result.Valid)
+    assert.Equal(t, 1, east.Requests() + west.Requests())
+}
+
+func TestEjectedEndpointIsProbedBeforeReadmission(t *testing.T) {
+    east, west := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer east.Close()
+    defer west.Close()
+    arcAuthClient := createFailoverClient(t, RoundRobin, east, west)
+    arcAuthClient.Endpoints.EjectionThreshold = 2
+    arcAuthClient.Endpoints.ProbeInterval = 20 * time.Millisecond
+    var mutex sync.Mutex
+    var changes []bool
+    arcAuthClient.Endpoints.OnHealthChange = func(host string, healthy bool) {
+        mutex.Lock()
+        changes = append(changes, healthy)
+        mutex.Unlock()
+        assert.Equal(t, east.URL + "/api/v1", host)
+    }
+
+    east.Script(arcauthtest.Burst(502, 2))
+    for i := 0; i < 4; i++ {
+        _, err := arcAuthClient.Authenticate("FakeDemoToken")
+        assert.NoError(t, err)
+    }
+    assert.False(t, arcAuthClient.Endpoints.Status()[0].Healthy)
+    assert.Equal(t, 2, east.Requests(), "an ejected endpoint gets no requests")
+
+    // the probes are started by traffic
+    for deadline := time.Now().Add(time.Second); !arcAuthClient.Endpoints.Status()[0].Healthy && time.Now().Before(deadline); {
+        arcAuthClient.Authenticate("FakeDemoToken")
+        time.Sleep(10 * time.Millisecond)
+    }
+    assert.True(t, arcAuthClient.Endpoints.Status()[0].Healthy)
+    mutex.Lock()
+    assert.Equal(t, []bool{false, true}, changes)
+    mutex.Unlock()
+}
+
+func TestAllEndpointsEjectedStillTried(t *testing.T) {
+    east, west := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer east.Close()
+    defer west.Close()
+    arcAuthClient := createFailoverClient(t, RoundRobin, east, west)
+    arcAuthClient.Endpoints.EjectionThreshold = 1
+    arcAuthClient.Endpoints.ProbeInterval = time.Hour
+    east.Script(arcauthtest.Burst(503, 1))
+    west.Script(arcauthtest.Burst(503, 1))
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+    assert.Error(t, err)
+    for _, status := range arcAuthClient.Endpoints.Status() {
+        assert.False(t, status.Healthy)
+    }
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+}
+
+func TestLeastLatencyPrefersFastEndpoint(t *testing.T) {
+    slow, fast := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer slow.Close()
+    defer fast.Close()
+    arcAuthClient := createFailoverClient(t, LeastLatency, slow, fast)
+    slow.Script(arcauthtest.Behavior{Latency: 30 * time.Millisecond, Times: 100})
+
+    for i := 0; i < 10; i++ {
+        _, err := arcAuthClient.Authenticate("FakeDemoToken")
+        assert.NoError(t, err)
+    }
+
+    assert.Equal(t, 1, slow.Requests(), "the slow endpoint is only used until it is measured")
+    status := arcAuthClient.Endpoints.Status()
+    assert.True(t, status[0].Latency > status[1].Latency)
+}
+
+func TestEmptyEndpointPoolIsAnInvalidHost(t *testing.T) {
+    for _, pool := range []*EndpointPool{NewEndpointPool(RoundRobin), &EndpointPool{}} {
+        for _, hedge := range []*HedgePolicy{nil, NewHedgePolicy(time.Millisecond)} {
+            arcAuthClient, _ := New("http://localhost", "user", "pass")
+            arcAuthClient.Endpoints = pool
+            arcAuthClient.Hedge = hedge
+
+            result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+            assert.Nil(t, result)
+            assert.True(t, errors.Is(err, ErrInvalidHost), "expected an invalid host but got %v", err)
+        }
+    }
+}
+
+func TestWithEndpointsValidation(t *testing.T) {
+    _, err := New("http://east", "user", "pass", WithEndpoints(""))
+    assert.Error(t, err)
+    _, err = New("http://east", "user", "pass", WithBalancing(Balancing(7)))
+    assert.Error(t, err)
+
+    arcAuthClient, err := New("http://east/", "user", "pass", WithEndpoints("http://west/"), WithBasePath("/auth/v2"))
+    assert.NoError(t, err)
+    status := arcAuthClient.Endpoints.Status()
+    assert.Equal(t, "http://east/auth/v2", status[0].Host)
+    assert.Equal(t, "http://west/auth/v2", status[1].Host)
+
+    arcAuthClient, _ = New("http://east", "user", "pass")
+    assert.Nil(t, arcAuthClient.Endpoints)
+}
+
+func TestProbeTreatsAnyAnswerButServerErrorAsHealthy(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, "someone", "else")
+
+    assert.NoError(t, arcAuthClient.probe(t.Context(), arcAuthClient.Host), "a 401 still means the server is up")
+    // scripted behaviors only apply to an authorized peer
+    peer, _ := New(server.URL, server.User(), server.Pass())
+    server.Script(arcauthtest.Burst(500, 1))
+    assert.Error(t, peer.probe(t.Context(), peer.Host))
+
+    closed := httptest.NewServer(nil)
+    closed.Close()
+    assert.Error(t, arcAuthClient.probe(t.Context(), closed.URL))
+}
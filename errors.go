@@ -0,0 +1,218 @@
+package arcauth
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "syscall"
+)
+
+/**
+ * ErrCanceled is returned when the caller's context was canceled before the arc-auth-server answered
+ */
+var ErrCanceled = errors.New("arc-auth call canceled")
+
+/**
+ * ErrDeadlineExceeded is returned when the caller's context deadline passed before the arc-auth-server answered,
+ * it also matches ErrTimeout
+ */
+var ErrDeadlineExceeded error = &kindError{message: "arc-auth call deadline exceeded", kind: ErrTimeout}
+
+/**
+ * The errors below classify failures, match them with errors.Is whatever the concrete error returned.  The details
+ * (status, masked token, attempt and server error body) are in the *ErrorResponse, *RequestError or
+ * *MalformedResponseError found with errors.As.  ErrRateLimited, ErrCircuitOpen, ErrCanceled and ErrDeadlineExceeded
+ * complete the set.
+ */
+var (
+    // ErrPeerUnauthorized matches a 401: the arc-auth-server rejected the client's peer credentials
+    ErrPeerUnauthorized = errors.New("arc-auth rejected the peer credentials")
+    // ErrForbidden matches a 403: the peer is known but not allowed to validate tokens
+    ErrForbidden = errors.New("arc-auth forbids the peer")
+    // ErrUnavailable matches a 5xx, a request that got no answer and an open circuit breaker
+    ErrUnavailable = errors.New("arc-auth server unavailable")
+    // ErrTimeout matches a request that timed out, a 408 or 504, and an expired context deadline
+    ErrTimeout = errors.New("arc-auth request timed out")
+    // ErrMalformedResponse matches a 200 whose body is not an identity document
+    ErrMalformedResponse = errors.New("arc-auth response is malformed")
+)
+
+/**
+ * The errors below tell why a request got no answer, a *RequestError matches at most one of them with errors.Is
+ */
+var (
+    // ErrInvalidHost matches a request that was not sent because the arc-auth-server URL is not an absolute http(s) URL
+    ErrInvalidHost = errors.New("arc-auth server URL is invalid")
+    // ErrNameResolution matches a request whose arc-auth-server host name could not be resolved
+    ErrNameResolution = errors.New("arc-auth server host name could not be resolved")
+    // ErrConnectionRefused matches a request whose connection the arc-auth-server host refused
+    ErrConnectionRefused = errors.New("arc-auth server refused the connection")
+    // ErrConnectionReset matches a connection that was reset or closed before the arc-auth-server answered
+    ErrConnectionReset = errors.New("arc-auth server connection reset")
+    // ErrTruncatedResponse matches an answer whose body could not be read to its end
+    ErrTruncatedResponse = errors.New("arc-auth response is truncated")
+)
+
+// errNoResponse is reported when a Doer returns neither a response nor an error
+var errNoResponse = errors.New("arc-auth request got neither a response nor an error")
+
+const (
+    // maxErrorBody bounds how much of a non-200 response body is kept
+    maxErrorBody = 64 << 10
+    // maxResponseBody bounds the identity document, a larger one is malformed
+    maxResponseBody = 1 << 20
+    // maxDrainedBody bounds how much of an unread body is drained to reuse its connection
+    maxDrainedBody = 256 << 10
+)
+
+/**
+ * ErrorResponse is returned when the arc-auth-server answered with another status than 200 or 204
+ *
+ * Code is the HTTP status and Message the one of the server's JSON error body ({"code":401,"message":"..."}) when it
+ * has one.  ErrorResponse matches ErrPeerUnauthorized, ErrForbidden, ErrRateLimited, ErrTimeout and ErrUnavailable
+ * according to its Code.
+ */
+type ErrorResponse struct {
+    Code    int     `json:"code"`
+    Message string  `json:"message"`
+    // Token is the masked token the request was about
+    Token   string  `json:"-"`
+    // Attempt is the number of the attempt that got this answer, see RetryPolicy
+    Attempt int     `json:"-"`
+    // Body is the response body, at most 64KiB of it
+    Body    []byte  `json:"-"`
+}
+
+func (e *ErrorResponse) Error() string {
+    return fmt.Sprintf("HTTP Code %d | %s", e.Code, e.Message)
+}
+
+func (e *ErrorResponse) Is(target error) bool {
+    switch target {
+    case ErrPeerUnauthorized:
+        return e.Code == http.StatusUnauthorized
+    case ErrForbidden:
+        return e.Code == http.StatusForbidden
+    case ErrRateLimited:
+        return e.Code == http.StatusTooManyRequests
+    case ErrTimeout:
+        return e.Code == http.StatusRequestTimeout || e.Code == http.StatusGatewayTimeout
+    case ErrUnavailable:
+        return e.Code >= 500
+    }
+    return false
+}
+
+/**
+ * newErrorResponse reads the body of an unexpected response into an ErrorResponse
+ */
+func newErrorResponse(response *http.Response, token string, attempt int) *ErrorResponse {
+    errorResponse := &ErrorResponse{Code: response.StatusCode, Message: "Non-20X response code", Token: token, Attempt: attempt}
+    errorResponse.Body, _ = io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+    var parsed struct {
+        Message string `json:"message"`
+    }
+    if json.Unmarshal(errorResponse.Body, &parsed) == nil && parsed.Message != "" {
+        errorResponse.Message = parsed.Message
+    }
+    return errorResponse
+}
+
+/**
+ * RequestError is returned when a request to the arc-auth-server got no answer, Err tells why: an invalid URL, a
+ * network failure, a timeout, a truncated answer, the caller giving up or the rate limiter holding the request back
+ *
+ * RequestError matches its Kind, ErrTimeout on a network timeout and ErrUnavailable unless the URL is invalid or the
+ * request was canceled, ran out of time or was rate limited.
+ */
+type RequestError struct {
+    // Token is the masked token the request was about
+    Token   string
+    // Attempt is the number of the attempt that failed, see RetryPolicy
+    Attempt int
+    // Kind is ErrInvalidHost, ErrNameResolution, ErrConnectionRefused, ErrConnectionReset or ErrTruncatedResponse, nil
+    // for other failures
+    Kind    error
+    Err     error
+}
+
+/**
+ * newRequestError wraps the error of a request that got no answer, telling its Kind
+ */
+func newRequestError(ctx context.Context, token string, attempt int, err error) *RequestError {
+    requestError := &RequestError{Token: token, Attempt: attempt, Err: contextError(ctx, err)}
+    if ctx.Err() != nil {
+        return requestError
+    }
+    var dnsError *net.DNSError
+    switch {
+    case errors.As(err, &dnsError):
+        requestError.Kind = ErrNameResolution
+    case errors.Is(err, syscall.ECONNREFUSED):
+        requestError.Kind = ErrConnectionRefused
+    case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+        requestError.Kind = ErrConnectionReset
+    case strings.Contains(err.Error(), "server closed idle connection"):
+        requestError.Kind = ErrConnectionReset
+    }
+    return requestError
+}
+
+func (e *RequestError) Error() string {
+    return fmt.Sprintf("arc-auth request for token %s (attempt %d) failed: %v", e.Token, e.Attempt, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+    return e.Err
+}
+
+func (e *RequestError) Is(target error) bool {
+    if e.Kind != nil && target == e.Kind {
+        return true
+    }
+    switch target {
+    case ErrTimeout:
+        var netError net.Error
+        return errors.As(e.Err, &netError) && netError.Timeout()
+    case ErrUnavailable:
+        return e.Kind != ErrInvalidHost && !errors.Is(e.Err, ErrCanceled) && !errors.Is(e.Err, ErrDeadlineExceeded) &&
+            !errors.Is(e.Err, ErrRateLimited)
+    }
+    return false
+}
+
+/**
+ * kindError is a sentinel error that also matches the broader sentinel kind with errors.Is
+ */
+type kindError struct {
+    message string
+    kind    error
+}
+
+func (e *kindError) Error() string {
+    return e.message
+}
+
+func (e *kindError) Is(target error) bool {
+    return target == e.kind
+}
+
+/**
+ * contextError turns err into ErrCanceled or ErrDeadlineExceeded when it was caused by ctx being done
+ *
+ * The returned error also matches the original context.Canceled or context.DeadlineExceeded with errors.Is
+ */
+func contextError(ctx context.Context, err error) error {
+    switch ctx.Err() {
+    case context.Canceled:
+        return fmt.Errorf("%w: %w", ErrCanceled, context.Canceled)
+    case context.DeadlineExceeded:
+        return fmt.Errorf("%w: %w", ErrDeadlineExceeded, context.DeadlineExceeded)
+    }
+    return err
+}
@@ -0,0 +1,214 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func createSlowServer(delay time.Duration) *httptest.Server {
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-time.After(delay):
+        case <-r.Context().Done():
+        }
+        w.WriteHeader(http.StatusNoContent)
+    }))
+}
+
+func TestAuthenticateContextWhenCanceled(t *testing.T) {
+    testServer := createSlowServer(5 * time.Second)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(20*time.Millisecond, cancel)
+
+    started := time.Now()
+    _, err := arcAuthClient.AuthenticateContext(ctx, "FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrCanceled), "expected ErrCanceled but got %v", err)
+    assert.True(t, errors.Is(err, context.Canceled))
+    assert.False(t, errors.Is(err, ErrDeadlineExceeded))
+    assert.True(t, time.Since(started) < time.Second, "the call should not wait for the server once canceled")
+}
+
+func TestAuthenticateContextWhenDeadlineExceeded(t *testing.T) {
+    testServer := createSlowServer(5 * time.Second)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+
+    _, err := arcAuthClient.AuthenticateContext(ctx, "FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrDeadlineExceeded), "expected ErrDeadlineExceeded but got %v", err)
+    assert.True(t, errors.Is(err, context.DeadlineExceeded))
+    assert.False(t, errors.Is(err, ErrCanceled))
+}
+
+func TestAuthContextWithinDeadline(t *testing.T) {
+    testServer := createSlowServer(time.Millisecond)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    body, err := arcAuthClient.AuthContext(ctx, "FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "{}", body)
+}
+
+func TestErrorTaxonomyByStatus(t *testing.T) {
+    sentinels := []error{ErrPeerUnauthorized, ErrForbidden, ErrRateLimited, ErrTimeout, ErrUnavailable}
+    cases := []struct {
+        status  int
+        matches []error
+    }{
+        {http.StatusUnauthorized, []error{ErrPeerUnauthorized}},
+        {http.StatusForbidden, []error{ErrForbidden}},
+        {http.StatusTooManyRequests, []error{ErrRateLimited}},
+        {http.StatusInternalServerError, []error{ErrUnavailable}},
+        {http.StatusServiceUnavailable, []error{ErrUnavailable}},
+        {http.StatusGatewayTimeout, []error{ErrTimeout, ErrUnavailable}},
+        {http.StatusNotFound, nil},
+    }
+    for _, c := range cases {
+        testServer := httptest.NewServer(createHandlerFunc(c.status, ""))
+        arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+        _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+        for _, sentinel := range sentinels {
+            expected := false
+            for _, match := range c.matches {
+                expected = expected || match == sentinel
+            }
+            assert.Equal(t, expected, errors.Is(err, sentinel), "status %d and %v", c.status, sentinel)
+        }
+        testServer.Close()
+    }
+}
+
+func TestErrorResponseCarriesDetails(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), "wrong-pass")
+    arcAuthClient.Retry = fastRetryPolicy()
+    arcAuthClient.Retry.RetryableStatuses = []int{http.StatusUnauthorized}
+    arcAuthClient.Retry.MaxAttempts = 2
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrPeerUnauthorized))
+    var errorResponse *ErrorResponse
+    assert.True(t, errors.As(err, &errorResponse))
+    assert.Equal(t, http.StatusUnauthorized, errorResponse.Code)
+    assert.Equal(t, "Unauthorized peer", errorResponse.Message, "the message comes from the server error body")
+    assert.Equal(t, arcAuthClient.Mask("FakeDemoToken"), errorResponse.Token)
+    assert.Equal(t, 2, errorResponse.Attempt)
+    assert.Equal(t, `{"code":401,"message":"Unauthorized peer"}`, string(errorResponse.Body))
+    assert.Equal(t, "HTTP Code 401 | Unauthorized peer", errorResponse.Error())
+}
+
+func TestErrorResponseWithoutJSONBody(t *testing.T) {
+    testServer := httptest.NewServer(createHandlerFunc(http.StatusBadGateway, "<html>bad gateway</html>"))
+    defer testServer.Close()
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    var errorResponse *ErrorResponse
+    assert.True(t, errors.As(err, &errorResponse))
+    assert.Equal(t, "Non-20X response code", errorResponse.Message)
+    assert.Equal(t, "<html>bad gateway</html>", string(errorResponse.Body))
+    assert.Equal(t, 1, errorResponse.Attempt)
+}
+
+func TestMalformedResponseTaxonomy(t *testing.T) {
+    testServer := httptest.NewServer(createHandlerFunc(http.StatusOK, "not json"))
+    defer testServer.Close()
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrMalformedResponse))
+    assert.False(t, errors.Is(err, ErrUnavailable))
+    var malformed *MalformedResponseError
+    assert.True(t, errors.As(err, &malformed))
+    assert.Equal(t, http.StatusOK, malformed.Status)
+    assert.Equal(t, arcAuthClient.Mask("FakeDemoToken"), malformed.Token)
+    assert.Equal(t, 1, malformed.Attempt)
+}
+
+func TestRequestErrorTaxonomy(t *testing.T) {
+    testServer := createSlowServer(5 * time.Second)
+    defer testServer.Close()
+    arcAuthClient, _ := New(testServer.URL, "user", "pass", WithTimeout(20*time.Millisecond))
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrTimeout), "client timeouts are timeouts: %v", err)
+    assert.True(t, errors.Is(err, ErrUnavailable))
+    var requestError *RequestError
+    assert.True(t, errors.As(err, &requestError))
+    assert.Equal(t, arcAuthClient.Mask("FakeDemoToken"), requestError.Token)
+    assert.Equal(t, 1, requestError.Attempt)
+
+    closed := httptest.NewServer(nil)
+    closed.Close()
+    arcAuthClient = createArcAuthClient(t, closed.URL)
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+    assert.True(t, errors.Is(err, ErrUnavailable))
+    assert.False(t, errors.Is(err, ErrTimeout))
+}
+
+func TestContextErrorTaxonomy(t *testing.T) {
+    testServer := createSlowServer(5 * time.Second)
+    defer testServer.Close()
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    _, err := arcAuthClient.AuthenticateContext(ctx, "FakeDemoToken")
+    assert.True(t, errors.Is(err, ErrTimeout))
+    assert.False(t, errors.Is(err, ErrUnavailable), "the caller ran out of time, the server may be fine")
+
+    ctx, cancel = context.WithCancel(context.Background())
+    cancel()
+    _, err = arcAuthClient.AuthenticateContext(ctx, "FakeDemoToken")
+    assert.False(t, errors.Is(err, ErrTimeout))
+    assert.False(t, errors.Is(err, ErrUnavailable))
+}
+
+func TestCircuitOpenIsUnavailable(t *testing.T) {
+    assert.True(t, errors.Is(ErrCircuitOpen, ErrUnavailable))
+    assert.False(t, errors.Is(ErrUnavailable, ErrCircuitOpen))
+    assert.Equal(t, "arc-auth circuit breaker is open", ErrCircuitOpen.Error())
+}
+
+func TestClientRateLimitCarriesToken(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Limiter = NewRateLimiter(0.001, 1)
+    arcAuthClient.Limiter.FailFast = true
+
+    arcAuthClient.Authenticate("FakeDemoToken")
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrRateLimited))
+    assert.False(t, errors.Is(err, ErrUnavailable))
+    var requestError *RequestError
+    assert.True(t, errors.As(err, &requestError))
+    assert.Equal(t, arcAuthClient.Mask("FakeDemoToken"), requestError.Token)
+}
@@ -0,0 +1,201 @@
+package arcauth
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync/atomic"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+/**
+ * createFaultyServer accepts connections, reads one request from each and hands the connection to fault
+ */
+func createFaultyServer(t *testing.T, fault func(conn net.Conn)) string {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    assert.NoError(t, err)
+    t.Cleanup(func() { listener.Close() })
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                if _, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+                    fault(conn)
+                }
+            }()
+        }
+    }()
+    return "http://" + listener.Addr().String()
+}
+
+/**
+ * assertFault checks that err is a *RequestError of the given kind and no other
+ */
+func assertFault(t *testing.T, err error, kind error) {
+    var requestError *RequestError
+    assert.True(t, errors.As(err, &requestError), "expected a *RequestError but got %T %v", err, err)
+    for _, other := range []error{ErrInvalidHost, ErrNameResolution, ErrConnectionRefused, ErrConnectionReset, ErrTruncatedResponse} {
+        assert.Equal(t, other == kind, errors.Is(err, other), "%v for %v", other, err)
+    }
+}
+
+func TestFaultInvalidHost(t *testing.T) {
+    for _, server := range []string{"arc-auth.example.com", "ftp://arc-auth.example.com", "http:///", "http://arc auth/%zz"} {
+        arcAuthClient := createArcAuthClient(t, server)
+        arcAuthClient.Retry = fastRetryPolicy()
+
+        _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+        assertFault(t, err, ErrInvalidHost)
+        assert.False(t, errors.Is(err, ErrUnavailable), server)
+        var retryError *RetryError
+        assert.True(t, errors.As(err, &retryError))
+        assert.Equal(t, 1, len(retryError.Attempts), "an invalid URL is not retried")
+    }
+}
+
+func TestFaultNameResolution(t *testing.T) {
+    arcAuthClient, _ := New("http://arc-auth.invalid", "user", "pass", WithTransport(roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+        return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: request.URL.Hostname(), IsNotFound: true}}
+    })))
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assertFault(t, err, ErrNameResolution)
+    assert.True(t, errors.Is(err, ErrUnavailable))
+    assert.False(t, IsTransientNetworkError(err), "a missing host does not come back by retrying")
+}
+
+func TestFaultConnectionRefused(t *testing.T) {
+    closed := httptest.NewServer(nil)
+    closed.Close()
+    arcAuthClient := createArcAuthClient(t, closed.URL)
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assertFault(t, err, ErrConnectionRefused)
+    assert.True(t, IsTransientNetworkError(err))
+}
+
+func TestFaultConnectionReset(t *testing.T) {
+    url := createFaultyServer(t, func(conn net.Conn) {
+        // a zero linger makes Close send a RST instead of a FIN
+        conn.(*net.TCPConn).SetLinger(0)
+    })
+    arcAuthClient := createArcAuthClient(t, url)
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assertFault(t, err, ErrConnectionReset)
+    assert.True(t, IsTransientNetworkError(err))
+}
+
+func TestFaultTruncatedHeaders(t *testing.T) {
+    url := createFaultyServer(t, func(conn net.Conn) {
+        conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n"))
+    })
+    arcAuthClient := createArcAuthClient(t, url)
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assertFault(t, err, ErrConnectionReset)
+}
+
+func TestFaultTruncatedBody(t *testing.T) {
+    url := createFaultyServer(t, func(conn net.Conn) {
+        conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"user\":{\"id\":"))
+    })
+    arcAuthClient := createArcAuthClient(t, url)
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+    assertFault(t, err, ErrTruncatedResponse)
+    assert.True(t, IsTransientNetworkError(err))
+
+    body, err := arcAuthClient.Auth("FakeDemoToken")
+    assert.True(t, errors.Is(err, ErrTruncatedResponse), "Auth does not return half a body")
+    assert.Equal(t, "", body)
+}
+
+func TestFaultOversizedBody(t *testing.T) {
+    testServer := httptest.NewServer(createHandlerFunc(http.StatusOK, `{"user":"`+strings.Repeat("x", maxResponseBody)+`"}`))
+    defer testServer.Close()
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+    assert.True(t, errors.Is(err, ErrMalformedResponse))
+
+    _, err = arcAuthClient.Auth("FakeDemoToken")
+    assert.True(t, errors.Is(err, ErrMalformedResponse))
+}
+
+func TestFaultDoerWithoutResponse(t *testing.T) {
+    arcAuthClient, _ := New("http://arc-auth.example.com", "user", "pass", WithDoer(doerFunc(func(request *http.Request) (*http.Response, error) {
+        return nil, nil
+    })))
+
+    assert.NotPanics(t, func() {
+        _, err := arcAuthClient.Authenticate("FakeDemoToken")
+        assert.True(t, errors.Is(err, errNoResponse))
+    })
+    assert.NotPanics(t, func() {
+        assert.Error(t, arcAuthClient.Ping(context.Background()))
+    })
+}
+
+func TestFaultDoerWithoutBody(t *testing.T) {
+    arcAuthClient, _ := New("http://arc-auth.example.com", "user", "pass", WithDoer(doerFunc(func(request *http.Request) (*http.Response, error) {
+        return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+    })))
+
+    assert.NotPanics(t, func() {
+        _, err := arcAuthClient.Authenticate("FakeDemoToken")
+        assert.True(t, errors.Is(err, ErrUnavailable))
+    })
+}
+
+func TestFaultBodiesAreDrainedForReuse(t *testing.T) {
+    var connections, calls int32
+    testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch atomic.AddInt32(&calls, 1) % 3 {
+        case 0:
+            w.WriteHeader(http.StatusServiceUnavailable)
+            // more than is kept in the ErrorResponse, the rest is drained
+            w.Write([]byte(strings.Repeat("unavailable ", 10000)))
+        case 1:
+            w.WriteHeader(http.StatusNoContent)
+        case 2:
+            w.Write([]byte(vaughantJSON + "\n\n"))
+        }
+    }))
+    testServer.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+        if state == http.StateNew {
+            atomic.AddInt32(&connections, 1)
+        }
+    }
+    testServer.Start()
+    defer testServer.Close()
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+
+    for i := 0; i < 9; i++ {
+        arcAuthClient.Authenticate("FakeDemoToken")
+    }
+
+    assert.Equal(t, int32(9), atomic.LoadInt32(&calls))
+    assert.Equal(t, int32(1), atomic.LoadInt32(&connections), "every request reused the first connection")
+}
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (this doerFunc) Do(request *http.Request) (*http.Response, error) {
+    return this(request)
+}
@@ -0,0 +1,147 @@
+package arcauth
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "sync"
+    "time"
+)
+
+const (
+    // DefaultHealthTTL is how long a HealthChecker reuses the outcome of a ping
+    DefaultHealthTTL = 10 * time.Second
+    // DefaultPingTimeout bounds a ping made by a HealthChecker
+    DefaultPingTimeout = 2 * time.Second
+)
+
+/**
+ * Pinger checks that its upstream is reachable, *ArcAuthClient is the usual implementation
+ */
+type Pinger interface {
+    Ping(ctx context.Context) error
+}
+
+/**
+ * Ping checks that the arc-auth-server is reachable and accepts the client's peer credentials
+ *
+ * It asks the server about an empty token, which is answered with a 204 when the peer is authorized.  A rejected peer
+ * results in an error matching ErrPeerUnauthorized.  With an EndpointPool the ping succeeds when one endpoint answers.
+ * The cache, retries and the circuit breaker are bypassed so that the server itself is checked.
+ */
+func (this *ArcAuthClient) Ping(ctx context.Context) error {
+    _, err := this.fetch(ctx, "")
+    if err != nil {
+        this.logger().Warn("arc-auth ping failed", "error", err)
+    }
+    return err
+}
+
+/**
+ * HealthChecker serves readiness and liveness probes from the health of the arc-auth-server
+ *
+ * The outcome of a ping is reused for TTL (DefaultHealthTTL when zero) so that frequent probes do not hammer the
+ * arc-auth-server, concurrent probes share a single ping.  Each ping is bounded by Timeout (DefaultPingTimeout when
+ * zero).
+ */
+type HealthChecker struct {
+    Pinger  Pinger
+    TTL     time.Duration
+    Timeout time.Duration
+
+    mutex   sync.Mutex
+    checked time.Time
+    err     error
+    now     func() time.Time
+}
+
+/**
+ * Health is the outcome of a health check, as served by the readiness and liveness handlers
+ */
+type Health struct {
+    Healthy bool      `json:"healthy"`
+    Checked time.Time `json:"checked"`
+    Err     error     `json:"-"`
+}
+
+/**
+ * NewHealthChecker constructs a HealthChecker with the default TTL and timeout, pinger is usually an *ArcAuthClient
+ */
+func NewHealthChecker(pinger Pinger) *HealthChecker {
+    return &HealthChecker{Pinger: pinger, TTL: DefaultHealthTTL, Timeout: DefaultPingTimeout, now: time.Now}
+}
+
+/**
+ * Check returns the health of the upstream, pinging it when the last outcome is older than TTL
+ */
+func (this *HealthChecker) Check(ctx context.Context) Health {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    if this.checked.IsZero() || !this.clock().Before(this.checked.Add(this.ttl())) {
+        ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), this.timeout())
+        this.err = this.Pinger.Ping(ctx)
+        cancel()
+        this.checked = this.clock()
+    }
+    return Health{Healthy: this.err == nil, Checked: this.checked, Err: this.err}
+}
+
+/**
+ * ReadyHandler answers 200 while the upstream is healthy and 503 otherwise, for a readiness probe
+ */
+func (this *HealthChecker) ReadyHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        health := this.Check(r.Context())
+        status := http.StatusOK
+        if !health.Healthy {
+            status = http.StatusServiceUnavailable
+        }
+        writeHealth(w, status, health)
+    })
+}
+
+/**
+ * LiveHandler always answers 200 and reports the upstream health in its body, for a liveness probe
+ *
+ * An unhealthy arc-auth-server is no reason to restart the process, which could not fix it.
+ */
+func (this *HealthChecker) LiveHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        writeHealth(w, http.StatusOK, this.Check(r.Context()))
+    })
+}
+
+func writeHealth(w http.ResponseWriter, status int, health Health) {
+    body := struct {
+        Health
+        Error string `json:"error,omitempty"`
+    }{Health: health}
+    if health.Err != nil {
+        body.Error = health.Err.Error()
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(body)
+}
+
+func (this *HealthChecker) ttl() time.Duration {
+    if this.TTL <= 0 {
+        return DefaultHealthTTL
+    }
+    return this.TTL
+}
+
+func (this *HealthChecker) timeout() time.Duration {
+    if this.Timeout <= 0 {
+        return DefaultPingTimeout
+    }
+    return this.Timeout
+}
+
+func (this *HealthChecker) clock() time.Time {
+    if this.now == nil {
+        return time.Now()
+    }
+    return this.now()
+}
@@ -0,0 +1,140 @@
+package arcauth
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestPing(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+
+    assert.NoError(t, arcAuthClient.Ping(context.Background()))
+    assert.Equal(t, 1, server.Requests())
+}
+
+func TestPingRejectedPeer(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), "wrong-pass")
+
+    err := arcAuthClient.Ping(context.Background())
+
+    assert.True(t, errors.Is(err, ErrPeerUnauthorized))
+}
+
+func TestPingUnreachable(t *testing.T) {
+    server := arcauthtest.NewServer()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    server.Close()
+
+    assert.Error(t, arcAuthClient.Ping(context.Background()))
+}
+
+func TestPingBypassesCache(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Cache = NewCache(10, time.Minute, time.Minute)
+
+    arcAuthClient.Ping(context.Background())
+    arcAuthClient.Ping(context.Background())
+
+    assert.Equal(t, 2, server.Requests())
+}
+
+func TestHealthCheckerCachesOutcome(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    clock := newFakeClock()
+    checker := NewHealthChecker(arcAuthClient)
+    checker.now = clock.Now
+
+    var wait sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wait.Add(1)
+        go func() {
+            defer wait.Done()
+            assert.True(t, checker.Check(context.Background()).Healthy)
+        }()
+    }
+    wait.Wait()
+    assert.Equal(t, 1, server.Requests())
+
+    server.Script(arcauthtest.Burst(503, 1))
+    clock.Advance(DefaultHealthTTL)
+    health := checker.Check(context.Background())
+    assert.False(t, health.Healthy)
+    assert.Error(t, health.Err)
+    assert.Equal(t, clock.Now(), health.Checked)
+    assert.Equal(t, 2, server.Requests())
+}
+
+func TestReadyHandler(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    checker := NewHealthChecker(arcAuthClient)
+    checker.TTL = time.Nanosecond
+
+    recorder := httptest.NewRecorder()
+    checker.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil))
+    assert.Equal(t, http.StatusOK, recorder.Code)
+    var body map[string]interface{}
+    assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+    assert.Equal(t, true, body["healthy"])
+    assert.Nil(t, body["error"])
+
+    server.SetPeer(server.User(), "rotated-pass")
+    recorder = httptest.NewRecorder()
+    checker.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil))
+    assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+    assert.True(t, strings.Contains(recorder.Body.String(), "401"), recorder.Body.String())
+    assert.False(t, strings.Contains(recorder.Body.String(), arcAuthClient.Pass))
+}
+
+func TestLiveHandlerReportsButStaysUp(t *testing.T) {
+    server := arcauthtest.NewServer()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    server.Close()
+    checker := NewHealthChecker(arcAuthClient)
+
+    recorder := httptest.NewRecorder()
+    checker.LiveHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/live", nil))
+
+    assert.Equal(t, http.StatusOK, recorder.Code)
+    assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+    assert.True(t, strings.Contains(recorder.Body.String(), `"healthy":false`), recorder.Body.String())
+}
+
+func TestHealthCheckerBoundsPing(t *testing.T) {
+    checker := NewHealthChecker(pingerFunc(func(ctx context.Context) error {
+        <-ctx.Done()
+        return ctx.Err()
+    }))
+    checker.Timeout = 10 * time.Millisecond
+
+    started := time.Now()
+    health := checker.Check(context.Background())
+
+    assert.False(t, health.Healthy)
+    assert.True(t, time.Since(started) < time.Second)
+}
+
+type pingerFunc func(ctx context.Context) error
+
+func (this pingerFunc) Ping(ctx context.Context) error {
+    return this(ctx)
+}
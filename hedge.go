@@ -0,0 +1,205 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "math"
+    "sort"
+    "sync"
+    "time"
+)
+
+const (
+    // DefaultHedgeRatio caps hedged requests at a tenth of all requests
+    DefaultHedgeRatio = 0.1
+
+    // hedgeWindow is the number of recent response times the percentile is computed over
+    hedgeWindow = 100
+    // minHedgeSamples is the number of response times needed before the percentile replaces Delay
+    minHedgeSamples = 10
+)
+
+/**
+ * HedgePolicy makes ArcAuthClient send a second request when the first one is slow to answer
+ *
+ * When the first request has not answered after Delay, or after the Percentile (between 0 and 1, e.g. 0.95) of the
+ * recent response times once enough of them are known, the same request is sent to the next endpoint (or to Host again
+ * when there is no EndpointPool).  The first answer wins and the other request is canceled.  A zero delay disables
+ * hedging.
+ *
+ * MaxRatio caps the hedged requests as a fraction of all requests (DefaultHedgeRatio when zero), so that hedging
+ * cannot double the load on a server that is slow for everyone.  With a RateLimiter a hedge is only sent when a slot is free
+ * right away, and a hedge that gets no answer never beats a request still pending.
+ */
+type HedgePolicy struct {
+    Delay      time.Duration
+    Percentile float64
+    MaxRatio   float64
+
+    mutex      sync.Mutex
+    samples    [hedgeWindow]time.Duration
+    count      int
+    position   int
+    tokens     float64
+    calls      int64
+    hedges     int64
+}
+
+/**
+ * NewHedgePolicy constructs a HedgePolicy that hedges after a fixed delay
+ */
+func NewHedgePolicy(delay time.Duration) *HedgePolicy {
+    return &HedgePolicy{Delay: delay, MaxRatio: DefaultHedgeRatio, tokens: 1}
+}
+
+/**
+ * HedgeDelay returns how long a request is given before it is hedged
+ */
+func (this *HedgePolicy) HedgeDelay() time.Duration {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return this.hedgeDelay()
+}
+
+/**
+ * Hedged returns the number of calls so far and how many of them were hedged
+ */
+func (this *HedgePolicy) Hedged() (calls, hedges int64) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return this.calls, this.hedges
+}
+
+/**
+ * hedgeDelay is HedgeDelay, this.mutex must be held
+ */
+func (this *HedgePolicy) hedgeDelay() time.Duration {
+    if this.Percentile <= 0 || this.count < minHedgeSamples {
+        return this.Delay
+    }
+    samples := make([]time.Duration, this.count)
+    copy(samples, this.samples[:this.count])
+    sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+    index := int(this.Percentile * float64(len(samples)))
+    if index >= len(samples) {
+        index = len(samples) - 1
+    }
+    return samples[index]
+}
+
+/**
+ * start counts a call and returns the delay after which it should be hedged, zero for no hedge
+ */
+func (this *HedgePolicy) start() time.Duration {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    this.calls++
+    // every request earns a fraction of a hedge, the bucket allows short bursts but not more than the ratio over time
+    this.tokens += this.maxRatio()
+    if limit := math.Max(1, this.maxRatio() * hedgeWindow); this.tokens > limit {
+        this.tokens = limit
+    }
+    return this.hedgeDelay()
+}
+
+/**
+ * allow tells whether a hedge may be sent now and counts it when it does
+ */
+func (this *HedgePolicy) allow() bool {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    if this.tokens < 1 {
+        return false
+    }
+    this.tokens--
+    this.hedges++
+    return true
+}
+
+/**
+ * record adds the response time of a successful request to the ones the percentile is computed over
+ */
+func (this *HedgePolicy) record(latency time.Duration) {
+    this.mutex.Lock()
+    this.samples[this.position] = latency
+    this.position = (this.position + 1) % hedgeWindow
+    if this.count < hedgeWindow {
+        this.count++
+    }
+    this.mutex.Unlock()
+}
+
+func (this *HedgePolicy) maxRatio() float64 {
+    if this.MaxRatio <= 0 {
+        return DefaultHedgeRatio
+    }
+    return this.MaxRatio
+}
+
+/**
+ * hedged is exchange with a HedgePolicy: the request goes to targets[0], a hedge to the next target when it is slow,
+ * and the next targets in turn when they fail
+ */
+func (this *ArcAuthClient) hedged(ctx context.Context, targets []*endpoint, token string, credentials Credentials) (*AuthResult, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    // cancels the request that lost the race
+    defer cancel()
+
+    type answer struct {
+        result *AuthResult
+        err    error
+    }
+    answers := make(chan answer, len(targets) + 1)
+    next, pending := 0, 0
+    send := func(ctx context.Context) {
+        target := targets[next % len(targets)]
+        next++
+        pending++
+        go func() {
+            result, err := this.sendTo(ctx, target, token, credentials)
+            answers <- answer{result, err}
+        }()
+    }
+
+    var hedge <-chan time.Time
+    if delay := this.Hedge.start(); delay > 0 {
+        timer := time.NewTimer(delay)
+        defer timer.Stop()
+        hedge = timer.C
+    }
+    send(ctx)
+    var last answer
+    for pending > 0 {
+        select {
+        case <-hedge:
+            hedge = nil
+            // the hedge takes its rate limiter slot up front, it is not worth waiting for one
+            if this.Limiter != nil && !this.Limiter.Allow() {
+                break
+            }
+            if !this.Hedge.allow() {
+                if this.Limiter != nil {
+                    this.Limiter.release()
+                }
+                break
+            }
+            this.logger().Debug("arc-auth request is slow, hedging", "host", redacted(targets[next % len(targets)].host), "token", this.Mask(token))
+            send(withReservedSlot(ctx))
+        case last = <-answers:
+            pending--
+            // a request that was not answered loses the race to those still pending
+            unanswered := errors.Is(last.err, ErrRateLimited) || errors.Is(last.err, ErrCanceled) || errors.Is(last.err, ErrDeadlineExceeded)
+            if unanswered && pending > 0 {
+                continue
+            }
+            if !isBreakerFailure(last.err) {
+                return last.result, last.err
+            }
+            if next < len(targets) {
+                this.logger().Info("arc-auth failing over", "host", redacted(targets[next].host), "token", this.Mask(token), "error", last.err)
+                send(ctx)
+            }
+        }
+    }
+    return last.result, last.err
+}
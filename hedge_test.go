@@ -0,0 +1,184 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+/**
+ * responseObserver hands the error of every response to a channel
+ */
+type responseObserver struct {
+    NopObserver
+    errs chan error
+}
+
+func (this *responseObserver) OnResponse(ctx context.Context, event ObservedEvent) {
+    this.errs <- event.Err
+}
+
+func TestHedgeWinsWhenPrimaryIsSlow(t *testing.T) {
+    slow, fast := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer slow.Close()
+    defer fast.Close()
+    arcAuthClient := createFailoverClient(t, RoundRobin, slow, fast)
+    arcAuthClient.Hedge = NewHedgePolicy(20 * time.Millisecond)
+    observer := &responseObserver{errs: make(chan error, 2)}
+    arcAuthClient.Observer = observer
+    slow.Script(arcauthtest.Latency(2 * time.Second))
+
+    started := time.Now()
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "vaughant", result.User.Username)
+    assert.True(t, time.Since(started) < time.Second, "took %v", time.Since(started))
+    assert.NoError(t, <-observer.errs, "the hedge answers first")
+    assert.True(t, errors.Is(<-observer.errs, ErrCanceled), "the slow request is canceled")
+    calls, hedges := arcAuthClient.Hedge.Hedged()
+    assert.Equal(t, int64(1), calls)
+    assert.Equal(t, int64(1), hedges)
+    assert.Equal(t, 1, slow.Requests())
+    assert.Equal(t, 1, fast.Requests())
+}
+
+func TestNoHedgeWhenPrimaryIsFast(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Hedge = NewHedgePolicy(time.Second)
+
+    for i := 0; i < 5; i++ {
+        _, err := arcAuthClient.Authenticate("FakeDemoToken")
+        assert.NoError(t, err)
+    }
+
+    _, hedges := arcAuthClient.Hedge.Hedged()
+    assert.Equal(t, int64(0), hedges)
+    assert.Equal(t, 5, server.Requests())
+}
+
+func TestHedgeToSameHostWithoutEndpoints(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Hedge = NewHedgePolicy(20 * time.Millisecond)
+    server.Script(arcauthtest.Latency(2 * time.Second))
+
+    started := time.Now()
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+    assert.True(t, time.Since(started) < time.Second)
+}
+
+func TestHedgedFailover(t *testing.T) {
+    east, west := arcauthtest.NewServer(), arcauthtest.NewServer()
+    defer east.Close()
+    defer west.Close()
+    arcAuthClient := createFailoverClient(t, RoundRobin, east, west)
+    arcAuthClient.Hedge = NewHedgePolicy(time.Second)
+    east.Script(arcauthtest.Burst(503, 1))
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+    assert.Equal(t, 1, west.Requests())
+}
+
+func TestHedgeRateIsCapped(t *testing.T) {
+    policy := NewHedgePolicy(time.Millisecond)
+    policy.MaxRatio = 0.1
+
+    allowed := 0
+    for i := 0; i < 1000; i++ {
+        policy.start()
+        if policy.allow() {
+            allowed++
+        }
+    }
+
+    assert.True(t, allowed <= 101, "hedged %d of 1000 calls", allowed)
+    assert.True(t, allowed >= 99, "hedged %d of 1000 calls", allowed)
+}
+
+func TestHedgeDelayFollowsPercentile(t *testing.T) {
+    policy := NewHedgePolicy(time.Second)
+    policy.Percentile = 0.9
+
+    for i := 1; i < minHedgeSamples; i++ {
+        policy.record(time.Duration(i) * time.Millisecond)
+    }
+    assert.Equal(t, time.Second, policy.HedgeDelay(), "too few samples, the fixed delay is used")
+
+    for i := minHedgeSamples; i <= 100; i++ {
+        policy.record(time.Duration(i) * time.Millisecond)
+    }
+    assert.Equal(t, 91*time.Millisecond, policy.HedgeDelay())
+
+    for i := 0; i < hedgeWindow; i++ {
+        policy.record(5 * time.Millisecond)
+    }
+    assert.Equal(t, 5*time.Millisecond, policy.HedgeDelay(), "only recent samples count")
+}
+
+func TestHedgeHeldBackByRateLimiter(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Hedge = NewHedgePolicy(20 * time.Millisecond)
+    arcAuthClient.Limiter = NewRateLimiter(1, 1)
+    arcAuthClient.Limiter.FailFast = true
+    server.Script(arcauthtest.Latency(200 * time.Millisecond))
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err, "the slow request still answers when the limiter holds the hedge back")
+    assert.True(t, result.Valid)
+    _, hedges := arcAuthClient.Hedge.Hedged()
+    assert.Equal(t, int64(0), hedges, "no hedge without a limiter slot")
+    assert.Equal(t, 1, server.Requests())
+}
+
+func TestHedgeTakesRateLimiterSlot(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Hedge = NewHedgePolicy(20 * time.Millisecond)
+    arcAuthClient.Limiter = NewRateLimiter(0.001, 2)
+    arcAuthClient.Limiter.FailFast = true
+    server.Script(arcauthtest.Latency(2 * time.Second))
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+    _, hedges := arcAuthClient.Hedge.Hedged()
+    assert.Equal(t, int64(1), hedges)
+    assert.False(t, arcAuthClient.Limiter.Allow(), "the request and its hedge took a slot each")
+}
+
+func TestHedgeBudgetGivesBackLimiterSlot(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Hedge = NewHedgePolicy(20 * time.Millisecond)
+    arcAuthClient.Hedge.tokens = 0
+    arcAuthClient.Hedge.MaxRatio = 0.001
+    arcAuthClient.Limiter = NewRateLimiter(0.001, 2)
+    server.Script(arcauthtest.Latency(100 * time.Millisecond))
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    _, hedges := arcAuthClient.Hedge.Hedged()
+    assert.Equal(t, int64(0), hedges)
+    assert.True(t, arcAuthClient.Limiter.Allow(), "the slot taken for the denied hedge was given back")
+}
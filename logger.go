@@ -0,0 +1,31 @@
+package arcauth
+
+/**
+ * Logger receives the client's log messages as a message and alternating key/value pairs
+ *
+ * The method set is the one of *slog.Logger, so slog.Default() or any slog.New(handler) can be used directly.
+ * The client never passes the peer password or an unmasked token to its Logger.
+ */
+type Logger interface {
+    Debug(msg string, args ...any)
+    Info(msg string, args ...any)
+    Warn(msg string, args ...any)
+    Error(msg string, args ...any)
+}
+
+/**
+ * NopLogger discards everything, it is what the client uses when no Logger is set
+ */
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...any) {}
+func (NopLogger) Info(msg string, args ...any)  {}
+func (NopLogger) Warn(msg string, args ...any)  {}
+func (NopLogger) Error(msg string, args ...any) {}
+
+func (this *ArcAuthClient) logger() Logger {
+    if this.Logger == nil {
+        return NopLogger{}
+    }
+    return this.Logger
+}
@@ -0,0 +1,303 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "expvar"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/**
+ * DefaultLatencyBuckets are the upper bounds, in seconds, of the latency histograms
+ */
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+/**
+ * Metrics counts what ArcAuthClient does, set it on the client (or use Metered around any Authenticator) and
+ * expose it with Handler in the Prometheus text format or with PublishExpvar
+ *
+ * A call is one AuthenticateContext, answered from the cache or by one or more requests to the arc-auth-server.
+ * Calls are counted by outcome (valid, invalid, error), requests by status class (2xx, 4xx, 5xx, or "none" when the
+ * server did not answer) and errors by kind.  Both have a latency histogram and an in-flight gauge.
+ */
+type Metrics struct {
+    calls            counterVec
+    errors           counterVec
+    requests         counterVec
+    cacheLookups     counterVec
+    callDuration     *histogram
+    requestDuration  *histogram
+    callsInFlight    int64
+    requestsInFlight int64
+}
+
+/**
+ * NewMetrics constructs empty Metrics using DefaultLatencyBuckets
+ */
+func NewMetrics() *Metrics {
+    return &Metrics{
+        callDuration:    newHistogram(DefaultLatencyBuckets),
+        requestDuration: newHistogram(DefaultLatencyBuckets),
+    }
+}
+
+/**
+ * MetricsSnapshot is a point in time copy of Metrics, it is what PublishExpvar publishes
+ */
+type MetricsSnapshot struct {
+    Calls            map[string]uint64 `json:"calls"`
+    Errors           map[string]uint64 `json:"errors"`
+    Requests         map[string]uint64 `json:"requests"`
+    CacheLookups     map[string]uint64 `json:"cache_lookups"`
+    CallsInFlight    int64             `json:"calls_in_flight"`
+    RequestsInFlight int64             `json:"requests_in_flight"`
+    CallDuration     HistogramSnapshot `json:"call_duration_seconds"`
+    RequestDuration  HistogramSnapshot `json:"request_duration_seconds"`
+}
+
+/**
+ * HistogramSnapshot holds the cumulative count of observations below each bucket's upper bound
+ */
+type HistogramSnapshot struct {
+    Buckets []float64 `json:"buckets"`
+    Counts  []uint64  `json:"counts"`
+    Count   uint64    `json:"count"`
+    Sum     float64   `json:"sum"`
+}
+
+/**
+ * Snapshot copies the current values
+ */
+func (this *Metrics) Snapshot() MetricsSnapshot {
+    return MetricsSnapshot{
+        Calls:            this.calls.snapshot(),
+        Errors:           this.errors.snapshot(),
+        Requests:         this.requests.snapshot(),
+        CacheLookups:     this.cacheLookups.snapshot(),
+        CallsInFlight:    atomic.LoadInt64(&this.callsInFlight),
+        RequestsInFlight: atomic.LoadInt64(&this.requestsInFlight),
+        CallDuration:     this.callDuration.snapshot(),
+        RequestDuration:  this.requestDuration.snapshot(),
+    }
+}
+
+/**
+ * Handler serves the metrics in the Prometheus text exposition format
+ */
+func (this *Metrics) Handler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        this.WritePrometheus(w)
+    })
+}
+
+/**
+ * WritePrometheus writes the metrics in the Prometheus text exposition format
+ */
+func (this *Metrics) WritePrometheus(w io.Writer) {
+    snapshot := this.Snapshot()
+    writeCounter(w, "arcauth_calls_total", "Auth calls by outcome.", "outcome", snapshot.Calls)
+    writeCounter(w, "arcauth_errors_total", "Failed Auth calls by error kind.", "kind", snapshot.Errors)
+    writeCounter(w, "arcauth_requests_total", "Requests sent to the arc-auth-server by response status class.", "class", snapshot.Requests)
+    writeCounter(w, "arcauth_cache_lookups_total", "Token cache lookups by result.", "result", snapshot.CacheLookups)
+    writeGauge(w, "arcauth_calls_in_flight", "Auth calls in progress.", snapshot.CallsInFlight)
+    writeGauge(w, "arcauth_requests_in_flight", "Requests to the arc-auth-server in progress.", snapshot.RequestsInFlight)
+    writeHistogram(w, "arcauth_call_duration_seconds", "Latency of Auth calls.", snapshot.CallDuration)
+    writeHistogram(w, "arcauth_request_duration_seconds", "Latency of requests to the arc-auth-server.", snapshot.RequestDuration)
+}
+
+/**
+ * PublishExpvar publishes the metrics snapshot as the expvar variable name, it panics when name is already taken
+ */
+func (this *Metrics) PublishExpvar(name string) {
+    expvar.Publish(name, expvar.Func(func() any {
+        return this.Snapshot()
+    }))
+}
+
+/**
+ * startCall marks the beginning of a call, the returned function records its outcome
+ */
+func (this *Metrics) startCall() func(result *AuthResult, err error) {
+    started := time.Now()
+    atomic.AddInt64(&this.callsInFlight, 1)
+    return func(result *AuthResult, err error) {
+        atomic.AddInt64(&this.callsInFlight, -1)
+        this.callDuration.observe(time.Since(started).Seconds())
+        switch {
+        case err != nil:
+            this.calls.inc("error")
+            this.errors.inc(ErrorKind(err))
+        case result.Valid:
+            this.calls.inc("valid")
+        default:
+            this.calls.inc("invalid")
+        }
+    }
+}
+
+/**
+ * startRequest marks the beginning of a request to the arc-auth-server, the returned function records its status
+ * (0 when the server did not answer)
+ */
+func (this *Metrics) startRequest() func(status int) {
+    started := time.Now()
+    atomic.AddInt64(&this.requestsInFlight, 1)
+    return func(status int) {
+        atomic.AddInt64(&this.requestsInFlight, -1)
+        this.requestDuration.observe(time.Since(started).Seconds())
+        this.requests.inc(statusClass(status))
+    }
+}
+
+func (this *Metrics) cacheLookup(hit bool) {
+    if hit {
+        this.cacheLookups.inc("hit")
+    } else {
+        this.cacheLookups.inc("miss")
+    }
+}
+
+/**
+ * Metered records the calls of the wrapped Authenticator in metrics
+ */
+func Metered(metrics *Metrics) Decorator {
+    return func(next Authenticator) Authenticator {
+        return AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+            done := metrics.startCall()
+            result, err := next.AuthenticateContext(ctx, token)
+            done(result, err)
+            return result, err
+        })
+    }
+}
+
+/**
+ * ErrorKind classifies err for metrics and alerting: canceled, deadline_exceeded, circuit_open, rate_limited, status,
+ * malformed, timeout, network or other
+ */
+func ErrorKind(err error) string {
+    var errorResponse *ErrorResponse
+    var malformed *MalformedResponseError
+    switch {
+    case errors.Is(err, ErrCanceled):
+        return "canceled"
+    case errors.Is(err, ErrDeadlineExceeded):
+        return "deadline_exceeded"
+    case errors.Is(err, ErrCircuitOpen):
+        return "circuit_open"
+    case errors.Is(err, ErrRateLimited):
+        return "rate_limited"
+    case errors.As(err, &errorResponse):
+        return "status"
+    case errors.As(err, &malformed):
+        return "malformed"
+    case errors.Is(err, ErrTimeout):
+        return "timeout"
+    case IsTransientNetworkError(err):
+        return "network"
+    }
+    return "other"
+}
+
+func statusClass(status int) string {
+    if status < 100 || status > 599 {
+        return "none"
+    }
+    return strconv.Itoa(status / 100) + "xx"
+}
+
+/**
+ * counterVec is a set of counters told apart by a single label value
+ */
+type counterVec struct {
+    mutex  sync.Mutex
+    values map[string]uint64
+}
+
+func (this *counterVec) inc(label string) {
+    this.mutex.Lock()
+    if this.values == nil {
+        this.values = make(map[string]uint64)
+    }
+    this.values[label]++
+    this.mutex.Unlock()
+}
+
+func (this *counterVec) snapshot() map[string]uint64 {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    snapshot := make(map[string]uint64, len(this.values))
+    for label, value := range this.values {
+        snapshot[label] = value
+    }
+    return snapshot
+}
+
+type histogram struct {
+    mutex   sync.Mutex
+    buckets []float64
+    counts  []uint64
+    count   uint64
+    sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+    return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (this *histogram) observe(value float64) {
+    this.mutex.Lock()
+    for i, bound := range this.buckets {
+        if value <= bound {
+            this.counts[i]++
+        }
+    }
+    this.count++
+    this.sum += value
+    this.mutex.Unlock()
+}
+
+func (this *histogram) snapshot() HistogramSnapshot {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return HistogramSnapshot{
+        Buckets: append([]float64(nil), this.buckets...),
+        Counts:  append([]uint64(nil), this.counts...),
+        Count:   this.count,
+        Sum:     this.sum,
+    }
+}
+
+func writeCounter(w io.Writer, name, help, label string, values map[string]uint64) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+    labels := make([]string, 0, len(values))
+    for value := range values {
+        labels = append(labels, value)
+    }
+    sort.Strings(labels)
+    for _, value := range labels {
+        fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, value, values[value])
+    }
+}
+
+func writeGauge(w io.Writer, name, help string, value int64) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
+
+func writeHistogram(w io.Writer, name, help string, snapshot HistogramSnapshot) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+    for i, bound := range snapshot.Buckets {
+        fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), snapshot.Counts[i])
+    }
+    fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, snapshot.Count)
+    fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(snapshot.Sum, 'g', -1, 64))
+    fmt.Fprintf(w, "%s_count %d\n", name, snapshot.Count)
+}
@@ -0,0 +1,122 @@
+package arcauth
+
+import (
+    "context"
+    "encoding/json"
+    "expvar"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestMetricsCountOutcomesAndStatusClasses(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Metrics = NewMetrics()
+
+    arcAuthClient.Authenticate("FakeDemoToken")
+    arcAuthClient.Authenticate("No Such Token")
+    server.Script(arcauthtest.Burst(503, 1))
+    arcAuthClient.Authenticate("FakeDemoToken")
+
+    snapshot := arcAuthClient.Metrics.Snapshot()
+    assert.Equal(t, map[string]uint64{"valid": 1, "invalid": 1, "error": 1}, snapshot.Calls)
+    assert.Equal(t, map[string]uint64{"2xx": 2, "5xx": 1}, snapshot.Requests)
+    assert.Equal(t, map[string]uint64{"status": 1}, snapshot.Errors)
+    assert.Equal(t, uint64(3), snapshot.CallDuration.Count)
+    assert.Equal(t, uint64(3), snapshot.RequestDuration.Count)
+    assert.Equal(t, int64(0), snapshot.CallsInFlight)
+    assert.Equal(t, int64(0), snapshot.RequestsInFlight)
+}
+
+func TestMetricsCountCacheLookupsAndNetworkErrors(t *testing.T) {
+    server := arcauthtest.NewServer()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Metrics = NewMetrics()
+    arcAuthClient.Cache = NewCache(10, time.Minute, time.Minute)
+
+    arcAuthClient.Authenticate("FakeDemoToken")
+    arcAuthClient.Authenticate("FakeDemoToken")
+    server.Close()
+    arcAuthClient.Authenticate("OtherToken")
+
+    snapshot := arcAuthClient.Metrics.Snapshot()
+    assert.Equal(t, map[string]uint64{"hit": 1, "miss": 2}, snapshot.CacheLookups)
+    assert.Equal(t, map[string]uint64{"2xx": 1, "none": 1}, snapshot.Requests)
+    assert.Equal(t, map[string]uint64{"network": 1}, snapshot.Errors)
+}
+
+func TestMetricsInFlightGauges(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Metrics = NewMetrics()
+
+    server.Script(arcauthtest.Latency(200 * time.Millisecond))
+    done := make(chan struct{})
+    go func() {
+        arcAuthClient.Authenticate("FakeDemoToken")
+        close(done)
+    }()
+    time.Sleep(50 * time.Millisecond)
+
+    snapshot := arcAuthClient.Metrics.Snapshot()
+    assert.Equal(t, int64(1), snapshot.CallsInFlight)
+    assert.Equal(t, int64(1), snapshot.RequestsInFlight)
+    <-done
+}
+
+func TestMetricsPrometheusHandler(t *testing.T) {
+    metrics := NewMetrics()
+    authenticator := Chain(AuthenticatorFunc(func(ctx context.Context, token string) (*AuthResult, error) {
+        return InvalidAuthResult(), nil
+    }), Metered(metrics))
+    authenticator.AuthenticateContext(context.Background(), "token")
+
+    recorder := httptest.NewRecorder()
+    metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+    body := recorder.Body.String()
+
+    assert.Equal(t, http.StatusOK, recorder.Code)
+    assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+    assert.Contains(t, body, "# TYPE arcauth_calls_total counter\n")
+    assert.Contains(t, body, "arcauth_calls_total{outcome=\"invalid\"} 1\n")
+    assert.Contains(t, body, "# TYPE arcauth_call_duration_seconds histogram\n")
+    assert.Contains(t, body, "arcauth_call_duration_seconds_bucket{le=\"+Inf\"} 1\n")
+    assert.Contains(t, body, "arcauth_call_duration_seconds_bucket{le=\"10\"} 1\n")
+    assert.Contains(t, body, "arcauth_call_duration_seconds_count 1\n")
+    assert.Contains(t, body, "# TYPE arcauth_calls_in_flight gauge\narcauth_calls_in_flight 0\n")
+}
+
+func TestMetricsHistogramBuckets(t *testing.T) {
+    histogram := newHistogram([]float64{0.1, 1})
+
+    histogram.observe(0.05)
+    histogram.observe(0.5)
+    histogram.observe(5)
+
+    snapshot := histogram.snapshot()
+    assert.Equal(t, []uint64{1, 2}, snapshot.Counts)
+    assert.Equal(t, uint64(3), snapshot.Count)
+    assert.InDelta(t, 5.55, snapshot.Sum, 0.0001)
+}
+
+func TestMetricsPublishExpvar(t *testing.T) {
+    metrics := NewMetrics()
+    metrics.calls.inc("valid")
+
+    // expvar names are global and cannot be published twice, every run (e.g. with -count) needs its own
+    name := fmt.Sprintf("arcauth_test_metrics_%d", time.Now().UnixNano())
+    metrics.PublishExpvar(name)
+
+    var snapshot MetricsSnapshot
+    assert.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &snapshot))
+    assert.Equal(t, uint64(1), snapshot.Calls["valid"])
+}
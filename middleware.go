@@ -0,0 +1,108 @@
+package arcauth
+
+import (
+    "context"
+    "net/http"
+)
+
+type contextKey int
+
+const identityContextKey contextKey = 0
+
+/**
+ * Middleware is a net/http middleware that authenticates every request with its AdmiralTokenHeader and stores the
+ * resulting identity in the request context, see FromContext
+ *
+ * Requests without a token, with an invalid token, or for which the arc-auth-server could not be asked are answered
+ * by the matching handler and never reach the wrapped handler.  A nil handler falls back to a plain 401 (missing or
+ * invalid token) or 503 (upstream error).
+ */
+type Middleware struct {
+    Authenticator  Authenticator
+    MissingToken   http.Handler
+    InvalidToken   http.Handler
+    UpstreamError  func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+/**
+ * NewMiddleware constructs a Middleware with the default responses, authenticator is usually an *ArcAuthClient
+ */
+func NewMiddleware(authenticator Authenticator) *Middleware {
+    return &Middleware{Authenticator: authenticator}
+}
+
+/**
+ * Handler wraps next so that it only sees authenticated requests
+ */
+func (this *Middleware) Handler(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token := r.Header.Get(AdmiralTokenHeader)
+        if token == "" {
+            this.missingToken().ServeHTTP(w, r)
+            return
+        }
+
+        result, err := this.Authenticator.AuthenticateContext(r.Context(), token)
+        if err != nil {
+            this.upstreamError(w, r, err)
+            return
+        }
+        if !result.Valid {
+            this.invalidToken().ServeHTTP(w, r)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), result)))
+    })
+}
+
+func (this *Middleware) missingToken() http.Handler {
+    if this.MissingToken != nil {
+        return this.MissingToken
+    }
+    return unauthorizedHandler
+}
+
+func (this *Middleware) invalidToken() http.Handler {
+    if this.InvalidToken != nil {
+        return this.InvalidToken
+    }
+    return unauthorizedHandler
+}
+
+func (this *Middleware) upstreamError(w http.ResponseWriter, r *http.Request, err error) {
+    if this.UpstreamError != nil {
+        this.UpstreamError(w, r, err)
+        return
+    }
+    http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+var unauthorizedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+})
+
+/**
+ * NewContext returns a copy of ctx carrying the identity of an authenticated token
+ */
+func NewContext(ctx context.Context, result *AuthResult) context.Context {
+    return context.WithValue(ctx, identityContextKey, result)
+}
+
+/**
+ * FromContext returns the identity stored by the Middleware, the second value is false outside of it
+ */
+func FromContext(ctx context.Context) (*AuthResult, bool) {
+    result, ok := ctx.Value(identityContextKey).(*AuthResult)
+    return result, ok && result != nil
+}
+
+/**
+ * MustFromContext is FromContext for handlers that are always wrapped by the Middleware, it panics otherwise
+ */
+func MustFromContext(ctx context.Context) *AuthResult {
+    result, ok := FromContext(ctx)
+    if !ok {
+        panic("arcauth: no identity in context, is the handler wrapped by the arcauth Middleware?")
+    }
+    return result
+}
@@ -0,0 +1,95 @@
+package arcauth
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+/**
+ * createArcAuthServer answers 200 with the vaughant fixture for FakeDemoToken and 204 for any other token
+ */
+func createArcAuthServer() *httptest.Server {
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get(AdmiralTokenHeader) != "FakeDemoToken" {
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        fmt.Fprint(w, vaughantJSON)
+    }))
+}
+
+var usernameHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    fmt.Fprint(w, MustFromContext(r.Context()).User.Username)
+})
+
+func serveWithToken(handler http.Handler, token string) *httptest.ResponseRecorder {
+    request := httptest.NewRequest("GET", "/stories", nil)
+    if token != "" {
+        request.Header.Set(AdmiralTokenHeader, token)
+    }
+    recorder := httptest.NewRecorder()
+    handler.ServeHTTP(recorder, request)
+    return recorder
+}
+
+func TestMiddlewareInjectsIdentity(t *testing.T) {
+    testServer := createArcAuthServer()
+    defer testServer.Close()
+
+    handler := NewMiddleware(createArcAuthClient(t, testServer.URL)).Handler(usernameHandler)
+    recorder := serveWithToken(handler, "FakeDemoToken")
+
+    assert.Equal(t, http.StatusOK, recorder.Code)
+    assert.Equal(t, "vaughant", recorder.Body.String())
+}
+
+func TestMiddlewareDefaultResponses(t *testing.T) {
+    testServer := createArcAuthServer()
+    defer testServer.Close()
+
+    handler := NewMiddleware(createArcAuthClient(t, testServer.URL)).Handler(usernameHandler)
+
+    assert.Equal(t, http.StatusUnauthorized, serveWithToken(handler, "").Code)
+    assert.Equal(t, http.StatusUnauthorized, serveWithToken(handler, "No Such Token").Code)
+
+    testServer.Close()
+    assert.Equal(t, http.StatusServiceUnavailable, serveWithToken(handler, "FakeDemoToken").Code)
+}
+
+func TestMiddlewareCustomResponses(t *testing.T) {
+    testServer := createArcAuthServer()
+    defer testServer.Close()
+
+    middleware := NewMiddleware(createArcAuthClient(t, testServer.URL))
+    middleware.MissingToken = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Redirect(w, r, "/login", http.StatusFound)
+    })
+    middleware.InvalidToken = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusForbidden)
+    })
+    var upstreamErr error
+    middleware.UpstreamError = func(w http.ResponseWriter, r *http.Request, err error) {
+        upstreamErr = err
+        w.WriteHeader(http.StatusBadGateway)
+    }
+    handler := middleware.Handler(usernameHandler)
+
+    assert.Equal(t, http.StatusFound, serveWithToken(handler, "").Code)
+    assert.Equal(t, http.StatusForbidden, serveWithToken(handler, "No Such Token").Code)
+
+    testServer.Close()
+    assert.Equal(t, http.StatusBadGateway, serveWithToken(handler, "FakeDemoToken").Code)
+    assert.Error(t, upstreamErr)
+}
+
+func TestFromContextOutsideMiddleware(t *testing.T) {
+    request := httptest.NewRequest("GET", "/", nil)
+
+    _, ok := FromContext(request.Context())
+    assert.False(t, ok)
+    assert.Panics(t, func() { MustFromContext(request.Context()) })
+}
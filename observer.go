@@ -0,0 +1,125 @@
+package arcauth
+
+import (
+    "context"
+    "time"
+)
+
+/**
+ * ObservedEvent describes a stage of an auth call to an Observer
+ *
+ * Token is always masked.  Attempt is the number of the request to the arc-auth-server the event belongs to (0 for
+ * events of the call as a whole), Status its HTTP status (0 when there was no answer) and Duration the time the
+ * stage took: the request for OnResponse, the upcoming backoff delay for OnRetry, the whole call for OnDecision.
+ */
+type ObservedEvent struct {
+    Token    string
+    Attempt  int
+    Status   int
+    Duration time.Duration
+    Err      error
+    CacheHit bool
+    Valid    bool
+}
+
+/**
+ * Observer is notified of every stage of an auth call, e.g. to trace or alert on it
+ *
+ * The callbacks are called synchronously, they must be quick and safe for concurrent use.  OnCache and OnDecision run on
+ * the goroutine of the call (an AuthMany worker for a batch) with the context it was given.  OnRequestStart, OnRetry and
+ * OnResponse run where the request is sent, the same goroutine and context by default, but with Coalesce that is the
+ * goroutine of the shared request, whose context is detached from the callers' cancellation, and with Hedge each
+ * request has its own goroutine and a context that is canceled once another request won.  Embed NopObserver to implement
+ * only some of them, and combine several observers with ChainObservers.
+ */
+type Observer interface {
+    // OnRequestStart is called before each request is sent to the arc-auth-server
+    OnRequestStart(ctx context.Context, event ObservedEvent)
+    // OnRetry is called when a failed request is about to be tried again, Err is the failure being retried
+    OnRetry(ctx context.Context, event ObservedEvent)
+    // OnResponse is called when a request to the arc-auth-server completed or failed
+    OnResponse(ctx context.Context, event ObservedEvent)
+    // OnCache is called after the token was looked up in the cache
+    OnCache(ctx context.Context, event ObservedEvent)
+    // OnDecision is called once per call with its final outcome
+    OnDecision(ctx context.Context, event ObservedEvent)
+}
+
+/**
+ * NopObserver ignores every event
+ */
+type NopObserver struct{}
+
+func (NopObserver) OnRequestStart(ctx context.Context, event ObservedEvent) {}
+func (NopObserver) OnRetry(ctx context.Context, event ObservedEvent)        {}
+func (NopObserver) OnResponse(ctx context.Context, event ObservedEvent)     {}
+func (NopObserver) OnCache(ctx context.Context, event ObservedEvent)        {}
+func (NopObserver) OnDecision(ctx context.Context, event ObservedEvent)     {}
+
+/**
+ * ChainObservers notifies each of observers in order, nil observers are skipped
+ */
+func ChainObservers(observers ...Observer) Observer {
+    chain := make(observerChain, 0, len(observers))
+    for _, observer := range observers {
+        if observer != nil {
+            chain = append(chain, observer)
+        }
+    }
+    return chain
+}
+
+type observerChain []Observer
+
+func (this observerChain) OnRequestStart(ctx context.Context, event ObservedEvent) {
+    for _, observer := range this {
+        observer.OnRequestStart(ctx, event)
+    }
+}
+
+func (this observerChain) OnRetry(ctx context.Context, event ObservedEvent) {
+    for _, observer := range this {
+        observer.OnRetry(ctx, event)
+    }
+}
+
+func (this observerChain) OnResponse(ctx context.Context, event ObservedEvent) {
+    for _, observer := range this {
+        observer.OnResponse(ctx, event)
+    }
+}
+
+func (this observerChain) OnCache(ctx context.Context, event ObservedEvent) {
+    for _, observer := range this {
+        observer.OnCache(ctx, event)
+    }
+}
+
+func (this observerChain) OnDecision(ctx context.Context, event ObservedEvent) {
+    for _, observer := range this {
+        observer.OnDecision(ctx, event)
+    }
+}
+
+type attemptContextKey struct{}
+
+/**
+ * withAttempt records in ctx the number of the request being sent, so that events of that request carry it
+ */
+func withAttempt(ctx context.Context, attempt int) context.Context {
+    return context.WithValue(ctx, attemptContextKey{}, attempt)
+}
+
+func attemptFrom(ctx context.Context) int {
+    if attempt, ok := ctx.Value(attemptContextKey{}).(int); ok {
+        return attempt
+    }
+    return 1
+}
+
+func (this *ArcAuthClient) observer() Observer {
+    if this.Observer == nil {
+        return NopObserver{}
+    }
+    return this.Observer
+}
@@ -0,0 +1,125 @@
+package arcauth
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+/**
+ * recordingObserver keeps a one line summary of every event it is given
+ */
+type recordingObserver struct {
+    mutex  sync.Mutex
+    events []string
+    tokens []string
+}
+
+func (this *recordingObserver) record(stage string, event ObservedEvent) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    summary := fmt.Sprintf("%s attempt=%d status=%d", stage, event.Attempt, event.Status)
+    switch stage {
+    case "cache":
+        summary = fmt.Sprintf("cache hit=%v", event.CacheHit)
+    case "decision":
+        summary = fmt.Sprintf("decision valid=%v status=%d err=%v", event.Valid, event.Status, event.Err != nil)
+    }
+    this.events = append(this.events, summary)
+    this.tokens = append(this.tokens, event.Token)
+}
+
+func (this *recordingObserver) OnRequestStart(ctx context.Context, event ObservedEvent) { this.record("start", event) }
+func (this *recordingObserver) OnRetry(ctx context.Context, event ObservedEvent)        { this.record("retry", event) }
+func (this *recordingObserver) OnResponse(ctx context.Context, event ObservedEvent)     { this.record("response", event) }
+func (this *recordingObserver) OnCache(ctx context.Context, event ObservedEvent)        { this.record("cache", event) }
+func (this *recordingObserver) OnDecision(ctx context.Context, event ObservedEvent)     { this.record("decision", event) }
+
+func TestObserverSeesEveryStage(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Retry = fastRetryPolicy()
+    arcAuthClient.Cache = NewCache(10, time.Minute, time.Minute)
+    observer := &recordingObserver{}
+    arcAuthClient.Observer = observer
+
+    server.Script(arcauthtest.Burst(503, 1))
+    arcAuthClient.Authenticate("FakeDemoToken")
+    arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.Equal(t, []string{
+        "cache hit=false",
+        "start attempt=1 status=0",
+        "response attempt=1 status=503",
+        "retry attempt=2 status=503",
+        "start attempt=2 status=0",
+        "response attempt=2 status=200",
+        "decision valid=true status=200 err=false",
+        "cache hit=true",
+        "decision valid=true status=200 err=false",
+    }, observer.events)
+    for _, token := range observer.tokens {
+        assert.Equal(t, arcAuthClient.Mask("FakeDemoToken"), token)
+    }
+}
+
+func TestObserverSeesErrors(t *testing.T) {
+    server := arcauthtest.NewServer()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    observer := &recordingObserver{}
+    arcAuthClient.Observer = observer
+    server.Close()
+
+    arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.Equal(t, "response attempt=1 status=0", observer.events[1])
+    assert.Equal(t, "decision valid=false status=0 err=true", observer.events[2])
+}
+
+func TestChainObservers(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    first, second := &recordingObserver{}, &recordingObserver{}
+    var decisions []time.Duration
+    tracer := &decisionObserver{onDecision: func(event ObservedEvent) { decisions = append(decisions, event.Duration) }}
+    arcAuthClient.Observer = ChainObservers(first, nil, second, tracer)
+
+    arcAuthClient.Authenticate("No Such Token")
+
+    assert.Equal(t, first.events, second.events)
+    assert.Equal(t, "decision valid=false status=204 err=false", first.events[len(first.events) - 1])
+    assert.Len(t, decisions, 1)
+    assert.True(t, decisions[0] > 0)
+}
+
+/**
+ * decisionObserver only implements OnDecision, the other callbacks come from the embedded NopObserver
+ */
+type decisionObserver struct {
+    NopObserver
+    onDecision func(ObservedEvent)
+}
+
+func (this *decisionObserver) OnDecision(ctx context.Context, event ObservedEvent) {
+    this.onDecision(event)
+}
+
+func TestObserverNeverSeesPlainToken(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    observer := &recordingObserver{}
+    arcAuthClient.Observer = observer
+
+    arcAuthClient.Authenticate(secretToken)
+
+    assert.False(t, strings.Contains(strings.Join(observer.tokens, " "), secretToken))
+}
@@ -0,0 +1,270 @@
+package arcauth
+
+import (
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    // DefaultBasePath is the path of the arc-auth-server API under its root FQDN
+    DefaultBasePath = "/api/v1"
+    // DefaultTimeout bounds a whole request to the arc-auth-server, including reading the response
+    DefaultTimeout = 10 * time.Second
+    // DefaultUserAgent is sent with every request unless WithUserAgent says otherwise
+    DefaultUserAgent = "arc-auth-go-client"
+)
+
+/**
+ * Doer sends HTTP requests, *http.Client is the usual implementation
+ */
+type Doer interface {
+    Do(request *http.Request) (*http.Response, error)
+}
+
+/**
+ * Option tunes the ArcAuthClient built by New, an invalid Option makes New return an error
+ */
+type Option func(*options) error
+
+type options struct {
+    timeout             time.Duration
+    transport           http.RoundTripper
+    doer                Doer
+    tlsConfig           *tls.Config
+    maxIdleConns        int
+    maxIdleConnsPerHost int
+    maxConnsPerHost     int
+    poolSet             bool
+    userAgent           string
+    basePath            string
+    logger              Logger
+    credentials         CredentialProvider
+    endpoints           []string
+    balancing           Balancing
+}
+
+func defaultOptions() *options {
+    return &options{
+        timeout:             DefaultTimeout,
+        maxIdleConns:        100,
+        maxIdleConnsPerHost: 32,
+        userAgent:           DefaultUserAgent,
+        basePath:            DefaultBasePath,
+    }
+}
+
+/**
+ * WithTimeout bounds every request to the arc-auth-server, zero means no timeout besides the caller's context
+ */
+func WithTimeout(timeout time.Duration) Option {
+    return func(this *options) error {
+        if timeout < 0 {
+            return fmt.Errorf("arcauth: timeout cannot be negative, got %v", timeout)
+        }
+        this.timeout = timeout
+        return nil
+    }
+}
+
+/**
+ * WithTransport sends the requests through transport instead of a transport built by New
+ *
+ * A custom transport cannot be combined with WithTLSConfig or WithConnectionPool unless it is an *http.Transport,
+ * which is then cloned before being tuned.
+ */
+func WithTransport(transport http.RoundTripper) Option {
+    return func(this *options) error {
+        if transport == nil {
+            return fmt.Errorf("arcauth: transport cannot be nil")
+        }
+        this.transport = transport
+        return nil
+    }
+}
+
+/**
+ * WithDoer sends the requests through doer, replacing the *http.Client entirely, e.g. for an instrumented client
+ *
+ * Transport, TLS, pool and timeout options do not apply to a Doer.
+ */
+func WithDoer(doer Doer) Option {
+    return func(this *options) error {
+        if doer == nil {
+            return fmt.Errorf("arcauth: doer cannot be nil")
+        }
+        this.doer = doer
+        return nil
+    }
+}
+
+/**
+ * WithTLSConfig uses config for https connections to the arc-auth-server, TLS 1.2 stays the minimum unless config
+ * sets its own MinVersion
+ */
+func WithTLSConfig(config *tls.Config) Option {
+    return func(this *options) error {
+        if config == nil {
+            return fmt.Errorf("arcauth: TLS config cannot be nil")
+        }
+        this.tlsConfig = config
+        return nil
+    }
+}
+
+/**
+ * WithConnectionPool sets the connection pool limits of the transport, zero means no limit
+ * maxIdle - idle (keep-alive) connections kept across all hosts
+ * maxIdlePerHost - idle connections kept to the arc-auth-server
+ * maxPerHost - connections opened to the arc-auth-server at once, including active ones
+ */
+func WithConnectionPool(maxIdle, maxIdlePerHost, maxPerHost int) Option {
+    return func(this *options) error {
+        if maxIdle < 0 || maxIdlePerHost < 0 || maxPerHost < 0 {
+            return fmt.Errorf("arcauth: connection pool limits cannot be negative")
+        }
+        this.maxIdleConns = maxIdle
+        this.maxIdleConnsPerHost = maxIdlePerHost
+        this.maxConnsPerHost = maxPerHost
+        this.poolSet = true
+        return nil
+    }
+}
+
+/**
+ * WithUserAgent sets the User-Agent header sent to the arc-auth-server
+ */
+func WithUserAgent(userAgent string) Option {
+    return func(this *options) error {
+        if userAgent == "" || strings.ContainsAny(userAgent, "\r\n") {
+            return fmt.Errorf("arcauth: user agent must be a non-empty single line")
+        }
+        this.userAgent = userAgent
+        return nil
+    }
+}
+
+/**
+ * WithBasePath sets the path of the arc-auth-server API under its root FQDN, DefaultBasePath otherwise
+ */
+func WithBasePath(basePath string) Option {
+    return func(this *options) error {
+        if basePath != "" && !strings.HasPrefix(basePath, "/") {
+            return fmt.Errorf("arcauth: base path must start with '/', got %q", basePath)
+        }
+        this.basePath = strings.TrimSuffix(basePath, "/")
+        return nil
+    }
+}
+
+/**
+ * WithLogger sets the client's Logger
+ */
+func WithLogger(logger Logger) Option {
+    return func(this *options) error {
+        this.logger = logger
+        return nil
+    }
+}
+
+/**
+ * WithCredentials asks provider for the peer credentials on every request instead of using the user and pass
+ * given to New, which then only serve as a fallback description of the peer
+ */
+func WithCredentials(provider CredentialProvider) Option {
+    return func(this *options) error {
+        if provider == nil {
+            return fmt.Errorf("arcauth: credential provider cannot be nil")
+        }
+        this.credentials = provider
+        return nil
+    }
+}
+
+/**
+ * WithEndpoints adds more arc-auth-servers, given like the server passed to New, and makes the client balance its
+ * requests over all of them and fail over between them (see EndpointPool)
+ */
+func WithEndpoints(servers ...string) Option {
+    return func(this *options) error {
+        for _, server := range servers {
+            if server == "" {
+                return fmt.Errorf("arcauth: endpoint cannot be empty")
+            }
+        }
+        this.endpoints = append(this.endpoints, servers...)
+        return nil
+    }
+}
+
+/**
+ * WithBalancing sets how requests are spread over the endpoints given with WithEndpoints, RoundRobin otherwise
+ */
+func WithBalancing(balancing Balancing) Option {
+    return func(this *options) error {
+        if balancing != RoundRobin && balancing != LeastLatency {
+            return fmt.Errorf("arcauth: unknown balancing %d", balancing)
+        }
+        this.balancing = balancing
+        return nil
+    }
+}
+
+/**
+ * httpClient builds the *http.Client the options describe, nil when a Doer replaces it
+ */
+func (this *options) httpClient() (*http.Client, error) {
+    if this.doer != nil {
+        return nil, nil
+    }
+
+    transport := this.transport
+    if transport == nil {
+        transport = newTransport()
+    }
+    tunable, isTransport := transport.(*http.Transport)
+    if !isTransport && (this.tlsConfig != nil || this.poolSet) {
+        return nil, fmt.Errorf("arcauth: TLS and connection pool options need an *http.Transport, got %T", transport)
+    }
+    if isTransport {
+        tunable = tunable.Clone()
+        // a transport given with WithTransport keeps its own pool limits unless WithConnectionPool overrides them
+        if this.transport == nil || this.poolSet {
+            tunable.MaxIdleConns = this.maxIdleConns
+            tunable.MaxIdleConnsPerHost = this.maxIdleConnsPerHost
+            tunable.MaxConnsPerHost = this.maxConnsPerHost
+        }
+        if this.tlsConfig != nil {
+            tunable.TLSClientConfig = this.tlsConfig.Clone()
+            if tunable.TLSClientConfig.MinVersion == 0 {
+                tunable.TLSClientConfig.MinVersion = tls.VersionTLS12
+            }
+            if tunable.DialTLSContext == nil && tunable.DialTLS == nil {
+                tunable.DialTLSContext = dialTLS(tunable)
+            }
+        }
+        transport = tunable
+    }
+    return &http.Client{Transport: transport, Timeout: this.timeout}, nil
+}
+
+/**
+ * newTransport is the production default transport: keep-alives, bounded dial and TLS handshake, TLS 1.2 or later
+ */
+func newTransport() *http.Transport {
+    return &http.Transport{
+        Proxy: http.ProxyFromEnvironment,
+        DialContext: (&net.Dialer{
+            Timeout:   5 * time.Second,
+            KeepAlive: 30 * time.Second,
+        }).DialContext,
+        ForceAttemptHTTP2:     true,
+        IdleConnTimeout:       90 * time.Second,
+        TLSHandshakeTimeout:   5 * time.Second,
+        ExpectContinueTimeout: time.Second,
+        TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12},
+    }
+}
@@ -0,0 +1,144 @@
+package arcauth
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (this roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+    return this(request)
+}
+
+func TestNewDefaults(t *testing.T) {
+    arcAuthClient, err := New("https://arc-auth.example.com/", "user", "pass")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "https://arc-auth.example.com/api/v1", arcAuthClient.Host)
+    assert.Equal(t, DefaultTimeout, arcAuthClient.HttpClient.Timeout)
+    assert.Equal(t, DefaultUserAgent, arcAuthClient.UserAgent)
+    transport := arcAuthClient.HttpClient.Transport.(*http.Transport)
+    assert.Equal(t, uint16(tls.VersionTLS12), transport.TLSClientConfig.MinVersion)
+    assert.Equal(t, 32, transport.MaxIdleConnsPerHost)
+}
+
+func TestNewWithOptions(t *testing.T) {
+    tlsConfig := &tls.Config{MinVersion: tls.VersionTLS13}
+    arcAuthClient, err := New("https://arc-auth.example.com", "user", "pass",
+        WithTimeout(2 * time.Second),
+        WithTLSConfig(tlsConfig),
+        WithConnectionPool(10, 5, 20),
+        WithBasePath("/api/v2/"),
+        WithUserAgent("stories-api/1.0"),
+    )
+
+    assert.NoError(t, err)
+    assert.Equal(t, "https://arc-auth.example.com/api/v2", arcAuthClient.Host)
+    assert.Equal(t, 2 * time.Second, arcAuthClient.HttpClient.Timeout)
+    assert.Equal(t, "stories-api/1.0", arcAuthClient.UserAgent)
+    transport := arcAuthClient.HttpClient.Transport.(*http.Transport)
+    assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+    assert.Equal(t, 10, transport.MaxIdleConns)
+    assert.Equal(t, 5, transport.MaxIdleConnsPerHost)
+    assert.Equal(t, 20, transport.MaxConnsPerHost)
+}
+
+func TestNewRejectsInvalidOptions(t *testing.T) {
+    invalid := map[string]Option{
+        "negative timeout":   WithTimeout(-time.Second),
+        "nil transport":      WithTransport(nil),
+        "nil doer":           WithDoer(nil),
+        "nil tls config":     WithTLSConfig(nil),
+        "negative pool":      WithConnectionPool(-1, 0, 0),
+        "multiline agent":    WithUserAgent("evil\r\nX-Admiral-Token: stolen"),
+        "relative base path": WithBasePath("api/v1"),
+    }
+    for name, option := range invalid {
+        _, err := New("https://arc-auth.example.com", "user", "pass", option)
+        assert.Error(t, err, name)
+    }
+}
+
+func TestTLSConfigKeepsTLS12Minimum(t *testing.T) {
+    tlsConfig := &tls.Config{RootCAs: x509.NewCertPool()}
+    arcAuthClient, err := New("https://arc-auth.example.com", "user", "pass", WithTLSConfig(tlsConfig))
+
+    assert.NoError(t, err)
+    transport := arcAuthClient.HttpClient.Transport.(*http.Transport)
+    assert.Equal(t, uint16(tls.VersionTLS12), transport.TLSClientConfig.MinVersion)
+    assert.Equal(t, tlsConfig.RootCAs, transport.TLSClientConfig.RootCAs)
+    assert.Equal(t, uint16(0), tlsConfig.MinVersion, "the caller's config is left untouched")
+}
+
+func TestNewRejectsTLSConfigOnCustomRoundTripper(t *testing.T) {
+    custom := roundTripperFunc(func(*http.Request) (*http.Response, error) { return nil, errors.New("unused") })
+
+    _, err := New("https://arc-auth.example.com", "user", "pass", WithTransport(custom), WithTLSConfig(&tls.Config{}))
+
+    assert.Error(t, err)
+}
+
+func TestCustomTransportKeepsItsPool(t *testing.T) {
+    custom := &http.Transport{MaxIdleConns: 10, MaxIdleConnsPerHost: 500, MaxConnsPerHost: 7}
+
+    arcAuthClient, err := New("https://arc-auth.example.com", "user", "pass", WithTransport(custom))
+    assert.NoError(t, err)
+    transport := arcAuthClient.HttpClient.Transport.(*http.Transport)
+    assert.Equal(t, 10, transport.MaxIdleConns)
+    assert.Equal(t, 500, transport.MaxIdleConnsPerHost)
+    assert.Equal(t, 7, transport.MaxConnsPerHost)
+
+    arcAuthClient, err = New("https://arc-auth.example.com", "user", "pass", WithTransport(custom), WithConnectionPool(20, 4, 2))
+    assert.NoError(t, err)
+    transport = arcAuthClient.HttpClient.Transport.(*http.Transport)
+    assert.Equal(t, 20, transport.MaxIdleConns)
+    assert.Equal(t, 4, transport.MaxIdleConnsPerHost)
+    assert.Equal(t, 2, transport.MaxConnsPerHost)
+    assert.Equal(t, 500, custom.MaxIdleConnsPerHost, "the given transport is cloned, not changed")
+}
+
+func TestClientUsesCustomTransportAndUserAgent(t *testing.T) {
+    var userAgent string
+    testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        userAgent = r.UserAgent()
+        w.WriteHeader(http.StatusNoContent)
+    }))
+    defer testServer.Close()
+
+    var used bool
+    transport := roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+        used = true
+        return http.DefaultTransport.RoundTrip(request)
+    })
+    arcAuthClient, _ := New(testServer.URL, "user", "pass", WithTransport(transport), WithUserAgent("stories-api/1.0"))
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.True(t, used)
+    assert.Equal(t, "stories-api/1.0", userAgent)
+}
+
+func TestClientUsesDoer(t *testing.T) {
+    var path string
+    doer := &http.Client{Transport: roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+        path = request.URL.Path
+        return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: request}, nil
+    })}
+    arcAuthClient, _ := New("https://arc-auth.example.com", "user", "pass", WithDoer(doer), WithBasePath("/auth-api"))
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.False(t, result.Valid)
+    assert.Equal(t, "/auth-api/auth", path)
+    assert.Nil(t, arcAuthClient.HttpClient)
+}
@@ -0,0 +1,195 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+)
+
+/**
+ * ErrRateLimited is returned instead of calling the arc-auth-server when the client's RateLimiter has no slot left
+ */
+var ErrRateLimited = errors.New("arc-auth client rate limit exceeded")
+
+/**
+ * RateLimiter is a token bucket that keeps ArcAuthClient under the arc-auth-server's per-peer request quota
+ *
+ * The bucket holds up to burst tokens and is refilled at rate tokens per second, every request to the server takes
+ * one.  When the bucket is empty a request waits for the next token unless FailFast is set, in which case it fails
+ * right away with ErrRateLimited.  A request that cannot get a token before its context deadline fails with
+ * ErrRateLimited without waiting.
+ *
+ * SetLimit changes the limits at runtime, FailFast must be set before the limiter is used.
+ */
+type RateLimiter struct {
+    FailFast bool
+
+    mutex    sync.Mutex
+    rate     float64
+    burst    int
+    tokens   float64
+    last     time.Time
+    now      func() time.Time
+}
+
+/**
+ * NewRateLimiter constructs a RateLimiter with a full bucket
+ * rate - the sustained number of requests per second, zero or less disables the limit
+ * burst - the number of requests that can be sent at once, at least 1
+ */
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+    limiter := &RateLimiter{now: time.Now}
+    limiter.SetLimit(rate, burst)
+    return limiter
+}
+
+/**
+ * SetLimit changes the rate and burst of the limiter, tokens already in the bucket are kept up to the new burst
+ */
+func (this *RateLimiter) SetLimit(rate float64, burst int) {
+    if burst < 1 {
+        burst = 1
+    }
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    now := this.clock()
+    if this.last.IsZero() {
+        this.tokens = float64(burst)
+    } else {
+        this.refill(now)
+    }
+    this.rate = rate
+    this.burst = burst
+    this.last = now
+    if this.tokens > float64(burst) {
+        this.tokens = float64(burst)
+    }
+}
+
+/**
+ * Limit returns the current rate and burst
+ */
+func (this *RateLimiter) Limit() (rate float64, burst int) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return this.rate, this.burst
+}
+
+/**
+ * Allow takes a token if one is available right now, it never waits
+ */
+func (this *RateLimiter) Allow() bool {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    if this.rate <= 0 {
+        return true
+    }
+    this.refill(this.clock())
+    if this.tokens < 1 {
+        return false
+    }
+    this.tokens--
+    return true
+}
+
+/**
+ * Wait takes a token, waiting for one when the bucket is empty and FailFast is not set
+ *
+ * It returns ErrRateLimited when no token is available in time and ErrCanceled or ErrDeadlineExceeded when ctx is done
+ * while waiting.
+ */
+func (this *RateLimiter) Wait(ctx context.Context) error {
+    if err := ctx.Err(); err != nil {
+        return contextError(ctx, err)
+    }
+    delay, ok := this.reserve(ctx)
+    if !ok {
+        return ErrRateLimited
+    }
+    if delay <= 0 {
+        return nil
+    }
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        this.release()
+        return contextError(ctx, ctx.Err())
+    }
+}
+
+/**
+ * reserve takes a token, possibly one that is only available after delay
+ */
+func (this *RateLimiter) reserve(ctx context.Context) (delay time.Duration, ok bool) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    if this.rate <= 0 {
+        return 0, true
+    }
+    now := this.clock()
+    this.refill(now)
+    if this.tokens >= 1 {
+        this.tokens--
+        return 0, true
+    }
+    if this.FailFast {
+        return 0, false
+    }
+    delay = time.Duration((1 - this.tokens) / this.rate * float64(time.Second))
+    if deadline, hasDeadline := ctx.Deadline(); hasDeadline && now.Add(delay).After(deadline) {
+        return delay, false
+    }
+    // the bucket goes below zero so that the waiters queue up for the next tokens
+    this.tokens--
+    return delay, true
+}
+
+/**
+ * release gives back a token that was reserved but not used
+ */
+func (this *RateLimiter) release() {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    this.tokens++
+    if this.tokens > float64(this.burst) {
+        this.tokens = float64(this.burst)
+    }
+}
+
+/**
+ * refill adds the tokens earned since the last refill, this.mutex must be held
+ */
+func (this *RateLimiter) refill(now time.Time) {
+    if elapsed := now.Sub(this.last); elapsed > 0 && this.rate > 0 {
+        this.tokens += elapsed.Seconds() * this.rate
+        if this.tokens > float64(this.burst) {
+            this.tokens = float64(this.burst)
+        }
+    }
+    this.last = now
+}
+
+func (this *RateLimiter) clock() time.Time {
+    if this.now == nil {
+        return time.Now()
+    }
+    return this.now()
+}
+
+type reservedSlotContextKey struct{}
+
+/**
+ * withReservedSlot records in ctx that a slot of the RateLimiter was already taken for the request being sent
+ */
+func withReservedSlot(ctx context.Context) context.Context {
+    return context.WithValue(ctx, reservedSlotContextKey{}, true)
+}
+
+func hasReservedSlot(ctx context.Context) bool {
+    reserved, _ := ctx.Value(reservedSlotContextKey{}).(bool)
+    return reserved
+}
@@ -0,0 +1,133 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/WPMedia/arc-auth-go-client/arcauthtest"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterAllowsBurstThenRefills(t *testing.T) {
+    clock := newFakeClock()
+    limiter := &RateLimiter{now: clock.Now}
+    limiter.SetLimit(2, 3)
+
+    assert.True(t, limiter.Allow())
+    assert.True(t, limiter.Allow())
+    assert.True(t, limiter.Allow())
+    assert.False(t, limiter.Allow())
+
+    clock.Advance(500 * time.Millisecond)
+    assert.True(t, limiter.Allow())
+    assert.False(t, limiter.Allow())
+
+    clock.Advance(time.Hour)
+    for i := 0; i < 3; i++ {
+        assert.True(t, limiter.Allow())
+    }
+    assert.False(t, limiter.Allow(), "the bucket never holds more than the burst")
+}
+
+func TestRateLimiterSetLimitAtRuntime(t *testing.T) {
+    clock := newFakeClock()
+    limiter := &RateLimiter{now: clock.Now}
+    limiter.SetLimit(1, 1)
+    assert.True(t, limiter.Allow())
+    assert.False(t, limiter.Allow())
+
+    limiter.SetLimit(0, 1)
+    rate, burst := limiter.Limit()
+    assert.Equal(t, float64(0), rate)
+    assert.Equal(t, 1, burst)
+    assert.True(t, limiter.Allow(), "a rate of zero disables the limit")
+
+    limiter.SetLimit(10, 5)
+    clock.Advance(time.Second)
+    for i := 0; i < 5; i++ {
+        assert.True(t, limiter.Allow())
+    }
+    assert.False(t, limiter.Allow())
+}
+
+func TestRateLimiterWaitsForSlot(t *testing.T) {
+    limiter := NewRateLimiter(50, 1)
+    assert.NoError(t, limiter.Wait(context.Background()))
+
+    started := time.Now()
+    assert.NoError(t, limiter.Wait(context.Background()))
+    assert.True(t, time.Since(started) >= 15*time.Millisecond, "waited %v", time.Since(started))
+}
+
+func TestRateLimiterFailsFast(t *testing.T) {
+    limiter := NewRateLimiter(1, 1)
+    limiter.FailFast = true
+    assert.NoError(t, limiter.Wait(context.Background()))
+
+    started := time.Now()
+    err := limiter.Wait(context.Background())
+
+    assert.True(t, errors.Is(err, ErrRateLimited))
+    assert.True(t, time.Since(started) < 50*time.Millisecond)
+}
+
+func TestRateLimiterDoesNotWaitPastDeadline(t *testing.T) {
+    limiter := NewRateLimiter(0.1, 1)
+    assert.NoError(t, limiter.Wait(context.Background()))
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+
+    started := time.Now()
+    err := limiter.Wait(ctx)
+
+    assert.True(t, errors.Is(err, ErrRateLimited))
+    assert.True(t, time.Since(started) < 100*time.Millisecond, "the 10s wait cannot fit in the 1s deadline")
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+    limiter := NewRateLimiter(1, 1)
+    assert.NoError(t, limiter.Wait(context.Background()))
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(10*time.Millisecond, cancel)
+
+    err := limiter.Wait(ctx)
+
+    assert.True(t, errors.Is(err, ErrCanceled))
+}
+
+func TestClientRateLimited(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Limiter = NewRateLimiter(1, 2)
+    arcAuthClient.Limiter.FailFast = true
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err)
+    _, err = arcAuthClient.Authenticate("FakeReaderToken")
+    assert.NoError(t, err)
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrRateLimited))
+    assert.Equal(t, "rate_limited", ErrorKind(err))
+    assert.Equal(t, 2, server.Requests())
+}
+
+func TestRateLimitedCallsDoNotTripBreaker(t *testing.T) {
+    server := arcauthtest.NewServer()
+    defer server.Close()
+    arcAuthClient, _ := New(server.URL, server.User(), server.Pass())
+    arcAuthClient.Limiter = NewRateLimiter(0.001, 1)
+    arcAuthClient.Limiter.FailFast = true
+    arcAuthClient.Breaker = NewCircuitBreaker(1, time.Minute)
+
+    arcAuthClient.Authenticate("FakeDemoToken")
+    for i := 0; i < 3; i++ {
+        _, err := arcAuthClient.Authenticate("FakeDemoToken")
+        assert.True(t, errors.Is(err, ErrRateLimited))
+    }
+
+    assert.Equal(t, BreakerClosed, arcAuthClient.Breaker.State())
+}
@@ -0,0 +1,85 @@
+package arcauth
+
+import (
+    "encoding/json"
+    "fmt"
+)
+
+/**
+ * AuthUser is the user the arc-auth-server associated with an authenticated token
+ */
+type AuthUser struct {
+    ID        int64  `json:"id"`
+    Username  string `json:"username"`
+    Email     string `json:"email"`
+    FirstName string `json:"first_name"`
+    LastName  string `json:"last_name"`
+}
+
+/**
+ * AuthOrganization is the organization the authenticated user belongs to
+ */
+type AuthOrganization struct {
+    ID   int64  `json:"id"`
+    Name string `json:"name"`
+}
+
+/**
+ * AuthResult is the decoded answer of the arc-auth-server for a single token
+ *
+ * Valid is false when the server answered 204/No Content, meaning the token is unknown or expired; in that case
+ * the identity fields are all zero values and Raw is empty.  Raw always holds the untouched JSON payload of a
+ * valid token so that callers can read fields this client does not know about yet.
+ */
+type AuthResult struct {
+    Valid        bool             `json:"-"`
+    User         AuthUser         `json:"user"`
+    Roles        []string         `json:"roles"`
+    Organization AuthOrganization `json:"organization"`
+    Raw          json.RawMessage  `json:"-"`
+}
+
+/**
+ * InvalidAuthResult is the result returned for a token the arc-auth-server does not recognize
+ */
+func InvalidAuthResult() *AuthResult {
+    return &AuthResult{Valid: false}
+}
+
+/**
+ * DecodeAuthResult builds a valid AuthResult out of the raw JSON the arc-auth-server returned for a token
+ */
+func DecodeAuthResult(raw []byte) (*AuthResult, error) {
+    result := &AuthResult{}
+    if err := json.Unmarshal(raw, result); err != nil {
+        return nil, &MalformedResponseError{Body: raw, Err: err}
+    }
+    result.Valid = true
+    result.Raw = append(json.RawMessage(nil), raw...)
+    return result, nil
+}
+
+/**
+ * MalformedResponseError is returned when the arc-auth-server answered 200 but the body is not an identity document,
+ * it matches ErrMalformedResponse
+ */
+type MalformedResponseError struct {
+    Body    []byte
+    Err     error
+    // Status, Token (masked) and Attempt are set when the body came from a request made by ArcAuthClient
+    Status  int
+    Token   string
+    Attempt int
+}
+
+func (e *MalformedResponseError) Error() string {
+    return fmt.Sprintf("Malformed arc-auth response body: %v", e.Err)
+}
+
+func (e *MalformedResponseError) Unwrap() error {
+    return e.Err
+}
+
+func (e *MalformedResponseError) Is(target error) bool {
+    return target == ErrMalformedResponse
+}
@@ -0,0 +1,43 @@
+package arcauth
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+const vaughantJSON = `{"user":{"id":7,"username":"vaughant","email":"vaughant@example.com","first_name":"Tim","last_name":"Vaughan"},"roles":["admin","editor"],"organization":{"id":3,"name":"WPMedia"}}`
+
+func TestDecodeAuthResult(t *testing.T) {
+    result, err := DecodeAuthResult([]byte(vaughantJSON))
+
+    assert.NoError(t, err)
+    assert.True(t, result.Valid)
+    assert.Equal(t, "vaughant", result.User.Username)
+    assert.Equal(t, int64(7), result.User.ID)
+    assert.Equal(t, []string{"admin", "editor"}, result.Roles)
+    assert.Equal(t, "WPMedia", result.Organization.Name)
+    assert.Equal(t, vaughantJSON, string(result.Raw))
+}
+
+func TestDecodeAuthResultKeepsUnknownFieldsInRaw(t *testing.T) {
+    result, err := DecodeAuthResult([]byte(`{"user":{"username":"vaughant"},"beta":true}`))
+
+    assert.NoError(t, err)
+    assert.Contains(t, string(result.Raw), `"beta":true`)
+}
+
+func TestDecodeAuthResultWithMalformedBody(t *testing.T) {
+    _, err := DecodeAuthResult([]byte("Hello, client"))
+
+    malformed, ok := err.(*MalformedResponseError)
+    assert.True(t, ok, "expected a *MalformedResponseError but got %v", err)
+    assert.Equal(t, "Hello, client", string(malformed.Body))
+}
+
+func TestInvalidAuthResult(t *testing.T) {
+    result := InvalidAuthResult()
+
+    assert.False(t, result.Valid)
+    assert.Empty(t, result.Raw)
+}
@@ -0,0 +1,284 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "math/rand"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+/**
+ * RetryPolicy tells ArcAuthClient when and how to try a failed request to the arc-auth-server again
+ *
+ * The delay before attempt n+1 is BaseDelay * 2^(n-1) capped at MaxDelay, Jitter (between 0 and 1) is the fraction
+ * of that delay that is randomized so that clients recovering together do not retry in lock step.  Only errors for
+ * which Retryable returns true are retried, when Retryable is nil RetryableStatuses and RetryNetworkErrors decide.
+ * A canceled or expired context is never retried.
+ */
+type RetryPolicy struct {
+    MaxAttempts        int
+    BaseDelay          time.Duration
+    MaxDelay           time.Duration
+    Jitter             float64
+    RetryableStatuses  []int
+    RetryNetworkErrors bool
+    Retryable          func(err error) bool
+
+    // Budget is optional, when set it caps how many retries are sent compared to regular requests
+    Budget             *RetryBudget
+}
+
+/**
+ * DefaultRetryPolicy retries up to 3 attempts on 502, 503, 504 and transient network errors with a retry budget
+ */
+func DefaultRetryPolicy() *RetryPolicy {
+    return &RetryPolicy{
+        MaxAttempts:        3,
+        BaseDelay:          50 * time.Millisecond,
+        MaxDelay:           time.Second,
+        Jitter:             0.5,
+        RetryableStatuses:  []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+        RetryNetworkErrors: true,
+        Budget:             NewRetryBudget(10, 0.1),
+    }
+}
+
+/**
+ * Delay returns how long to wait before the given attempt (the first retry is attempt 2)
+ */
+func (this *RetryPolicy) Delay(attempt int) time.Duration {
+    if attempt < 2 || this.BaseDelay <= 0 {
+        return 0
+    }
+    delay := this.BaseDelay
+    for i := 2; i < attempt; i++ {
+        // doubling past half of the largest duration would overflow, the delay stops growing there
+        if delay > math.MaxInt64 / 2 {
+            break
+        }
+        delay *= 2
+        if this.MaxDelay > 0 && delay >= this.MaxDelay {
+            break
+        }
+    }
+    if this.MaxDelay > 0 && delay > this.MaxDelay {
+        delay = this.MaxDelay
+    }
+    jitter := this.Jitter
+    if jitter > 1 {
+        jitter = 1
+    }
+    if randomized := time.Duration(float64(delay) * jitter); jitter > 0 && randomized > 0 {
+        delay = delay - randomized + time.Duration(rand.Int63n(int64(randomized)))
+    }
+    return delay
+}
+
+/**
+ * ShouldRetry tells whether err is worth another attempt according to this policy
+ */
+func (this *RetryPolicy) ShouldRetry(err error) bool {
+    if err == nil || errors.Is(err, ErrCanceled) || errors.Is(err, ErrDeadlineExceeded) {
+        return false
+    }
+    if this.Retryable != nil {
+        return this.Retryable(err)
+    }
+    var errorResponse *ErrorResponse
+    if errors.As(err, &errorResponse) {
+        for _, status := range this.RetryableStatuses {
+            if status == errorResponse.Code {
+                return true
+            }
+        }
+        return false
+    }
+    return this.RetryNetworkErrors && IsTransientNetworkError(err)
+}
+
+/**
+ * IsTransientNetworkError tells whether err is a connection level failure that may go away when trying again,
+ * e.g. a reset or refused connection, a truncated response or a timeout
+ */
+func IsTransientNetworkError(err error) bool {
+    if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+        return true
+    }
+    if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+        return true
+    }
+    var dnsError *net.DNSError
+    if errors.As(err, &dnsError) {
+        return dnsError.IsTemporary || dnsError.IsTimeout
+    }
+    var opError *net.OpError
+    if errors.As(err, &opError) {
+        return true
+    }
+    var netError net.Error
+    if errors.As(err, &netError) && netError.Timeout() {
+        return true
+    }
+    // the http transport reports some closed connections only by message
+    return err != nil && strings.Contains(err.Error(), "server closed idle connection")
+}
+
+/**
+ * RetryBudget bounds retries during an outage so that they cannot multiply the load on the arc-auth-server
+ *
+ * It works like the gRPC retry throttle: the budget starts full with MaxTokens, every failed attempt takes one token
+ * and every successful one gives back TokenRatio tokens.  Retries are only sent while more than half of the tokens
+ * are left, so a failing server sees roughly one request per caller instead of MaxAttempts.
+ */
+type RetryBudget struct {
+    MaxTokens  float64
+    TokenRatio float64
+
+    mutex      sync.Mutex
+    tokens     float64
+}
+
+/**
+ * NewRetryBudget constructs a full RetryBudget
+ */
+func NewRetryBudget(maxTokens, tokenRatio float64) *RetryBudget {
+    return &RetryBudget{MaxTokens: maxTokens, TokenRatio: tokenRatio, tokens: maxTokens}
+}
+
+/**
+ * Allow tells whether a retry may be sent right now
+ */
+func (this *RetryBudget) Allow() bool {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return this.tokens > this.MaxTokens / 2
+}
+
+func (this *RetryBudget) onSuccess() {
+    this.mutex.Lock()
+    this.tokens += this.TokenRatio
+    if this.tokens > this.MaxTokens {
+        this.tokens = this.MaxTokens
+    }
+    this.mutex.Unlock()
+}
+
+func (this *RetryBudget) onFailure() {
+    this.mutex.Lock()
+    this.tokens--
+    if this.tokens < 0 {
+        this.tokens = 0
+    }
+    this.mutex.Unlock()
+}
+
+/**
+ * Attempt describes one request sent to the arc-auth-server while answering a single call
+ */
+type Attempt struct {
+    Number   int
+    Status   int
+    Duration time.Duration
+    Err      error
+}
+
+/**
+ * RetryError is returned when every attempt allowed by the RetryPolicy failed, it wraps the last attempt's error
+ * so errors.Is and errors.As still see the underlying cause
+ */
+type RetryError struct {
+    Attempts []Attempt
+    Err      error
+}
+
+func (e *RetryError) Error() string {
+    return fmt.Sprintf("arc-auth call failed after %d attempt(s): %v", len(e.Attempts), e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+    return e.Err
+}
+
+/**
+ * fetchWithRetry asks the arc-auth-server about token, trying again on transient failures when a RetryPolicy is set
+ */
+func (this *ArcAuthClient) fetchWithRetry(ctx context.Context, token string) (*AuthResult, error) {
+    if this.Retry == nil {
+        return this.attempt(ctx, token)
+    }
+    observer := this.observer()
+    return this.Retry.do(ctx, func(ctx context.Context) (*AuthResult, error) {
+        return this.attempt(ctx, token)
+    }, func(next int, delay time.Duration, err error) {
+        observer.OnRetry(ctx, ObservedEvent{Token: this.Mask(token), Attempt: next, Status: statusOf(nil, err), Duration: delay, Err: err})
+    })
+}
+
+/**
+ * do calls fn until it succeeds or this policy gives up, onRetry (optional) is told about each retry before its delay
+ */
+func (this *RetryPolicy) do(ctx context.Context, fn func(context.Context) (*AuthResult, error), onRetry func(next int, delay time.Duration, err error)) (*AuthResult, error) {
+    var attempts []Attempt
+    for number := 1; ; number++ {
+        delay := this.Delay(number)
+        if number > 1 && onRetry != nil {
+            onRetry(number, delay, attempts[len(attempts) - 1].Err)
+        }
+        if delay > 0 {
+            timer := time.NewTimer(delay)
+            select {
+            case <-timer.C:
+            case <-ctx.Done():
+                timer.Stop()
+                return nil, &RetryError{Attempts: attempts, Err: contextError(ctx, ctx.Err())}
+            }
+        }
+
+        started := time.Now()
+        result, err := fn(withAttempt(ctx, number))
+        attempts = append(attempts, Attempt{Number: number, Status: statusOf(result, err), Duration: time.Since(started), Err: err})
+        if err == nil {
+            if this.Budget != nil {
+                this.Budget.onSuccess()
+            }
+            return result, nil
+        }
+
+        retryable := this.ShouldRetry(err)
+        if retryable && this.Budget != nil {
+            this.Budget.onFailure()
+        }
+        if !retryable || number >= this.MaxAttempts || (this.Budget != nil && !this.Budget.Allow()) {
+            return nil, &RetryError{Attempts: attempts, Err: err}
+        }
+    }
+}
+
+/**
+ * statusOf is the HTTP status code behind a fetch outcome, 0 when the server never answered
+ */
+func statusOf(result *AuthResult, err error) int {
+    if err == nil {
+        if result.Valid {
+            return http.StatusOK
+        }
+        return http.StatusNoContent
+    }
+    var errorResponse *ErrorResponse
+    if errors.As(err, &errorResponse) {
+        return errorResponse.Code
+    }
+    var malformed *MalformedResponseError
+    if errors.As(err, &malformed) {
+        return http.StatusOK
+    }
+    return 0
+}
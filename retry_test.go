@@ -0,0 +1,167 @@
+package arcauth
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+/**
+ * createFlakyServer answers the given status codes in order, then the vaughant fixture
+ */
+func createFlakyServer(calls *int32, statuses ...int) *httptest.Server {
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        call := int(atomic.AddInt32(calls, 1))
+        if call <= len(statuses) {
+            w.WriteHeader(statuses[call - 1])
+            return
+        }
+        fmt.Fprint(w, vaughantJSON)
+    }))
+}
+
+func fastRetryPolicy() *RetryPolicy {
+    policy := DefaultRetryPolicy()
+    policy.BaseDelay = time.Millisecond
+    policy.MaxDelay = 5 * time.Millisecond
+    policy.Budget = nil
+    return policy
+}
+
+func TestRetryRecoversFromTransientStatus(t *testing.T) {
+    var calls int32
+    testServer := createFlakyServer(&calls, http.StatusBadGateway, http.StatusServiceUnavailable)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Retry = fastRetryPolicy()
+
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "vaughant", result.User.Username)
+    assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+    var calls int32
+    testServer := createFlakyServer(&calls, 502, 502, 502, 502, 502)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Retry = fastRetryPolicy()
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    var retryError *RetryError
+    assert.True(t, errors.As(err, &retryError))
+    assert.Len(t, retryError.Attempts, 3)
+    for i, attempt := range retryError.Attempts {
+        assert.Equal(t, i + 1, attempt.Number)
+        assert.Equal(t, http.StatusBadGateway, attempt.Status)
+    }
+    var errorResponse *ErrorResponse
+    assert.True(t, errors.As(err, &errorResponse), "the last attempt's error is wrapped")
+    assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRetryDoesNotRetryOtherStatuses(t *testing.T) {
+    var calls int32
+    testServer := createFlakyServer(&calls, http.StatusUnauthorized)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Retry = fastRetryPolicy()
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.Error(t, err)
+    assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetryNetworkErrors(t *testing.T) {
+    testServer := httptest.NewServer(http.HandlerFunc(createHandlerFunc(200, vaughantJSON)))
+    url := testServer.URL
+    testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, url)
+    arcAuthClient.Retry = fastRetryPolicy()
+
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    var retryError *RetryError
+    assert.True(t, errors.As(err, &retryError))
+    assert.Len(t, retryError.Attempts, 3, "a refused connection is retried")
+    assert.Equal(t, 0, retryError.Attempts[0].Status)
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+    var calls int32
+    testServer := createFlakyServer(&calls, 502, 502, 502)
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Retry = fastRetryPolicy()
+    arcAuthClient.Retry.BaseDelay = time.Hour
+    arcAuthClient.Retry.MaxDelay = time.Hour
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    _, err := arcAuthClient.AuthenticateContext(ctx, "FakeDemoToken")
+
+    assert.True(t, errors.Is(err, ErrDeadlineExceeded), "expected ErrDeadlineExceeded but got %v", err)
+    assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetryBudgetLimitsRetriesDuringOutage(t *testing.T) {
+    var calls int32
+    testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&calls, 1)
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }))
+    defer testServer.Close()
+
+    arcAuthClient := createArcAuthClient(t, testServer.URL)
+    arcAuthClient.Retry = fastRetryPolicy()
+    arcAuthClient.Retry.Budget = NewRetryBudget(4, 0.1)
+
+    for i := 0; i < 10; i++ {
+        arcAuthClient.Authenticate("FakeDemoToken")
+    }
+
+    // without a budget 10 calls would send 30 requests
+    assert.True(t, atomic.LoadInt32(&calls) <= 12, "sent %d requests", atomic.LoadInt32(&calls))
+    assert.False(t, arcAuthClient.Retry.Budget.Allow())
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+    policy := &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+    assert.Equal(t, time.Duration(0), policy.Delay(1))
+    assert.Equal(t, 10 * time.Millisecond, policy.Delay(2))
+    assert.Equal(t, 20 * time.Millisecond, policy.Delay(3))
+    assert.Equal(t, 40 * time.Millisecond, policy.Delay(4))
+    assert.Equal(t, 50 * time.Millisecond, policy.Delay(5))
+    assert.Equal(t, 50 * time.Millisecond, policy.Delay(60))
+
+    policy.Jitter = 0.5
+    for i := 0; i < 100; i++ {
+        delay := policy.Delay(3)
+        assert.True(t, delay >= 10 * time.Millisecond && delay <= 20 * time.Millisecond, "delay %v out of range", delay)
+    }
+    unbounded := &RetryPolicy{MaxAttempts: 60, BaseDelay: 50 * time.Millisecond, Jitter: 0.5}
+    for attempt := 2; attempt <= 200; attempt++ {
+        assert.NotPanics(t, func() {
+            assert.True(t, unbounded.Delay(attempt) > 0, "attempt %d", attempt)
+        })
+    }
+    unbounded.Jitter = 1
+    assert.NotPanics(t, func() { unbounded.Delay(1000) })
+}
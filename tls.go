@@ -0,0 +1,301 @@
+package arcauth
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/base64"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/**
+ * TLSSettings describes how the client secures its connection to the arc-auth-server
+ *
+ * CertFile and KeyFile hold the client certificate for mutual TLS, CAFile a PEM bundle of the private CAs that may
+ * sign the server certificate (the system roots are used when empty).  Pins are SPKI pins in the "sha256/<base64>"
+ * form (see SPKIPin), when set the server's chain must contain one of them.  MinVersion defaults to TLS 1.2.
+ *
+ * The files are checked for changes on every handshake and reloaded when rotated, so new certificates are picked up
+ * by the next connection without restarting.  With a CAFile the certificate is checked against the server name of the
+ * connection, a *tls.Config from NewTLSConfig used outside of New therefore rejects a server addressed by IP.
+ */
+type TLSSettings struct {
+    CertFile   string
+    KeyFile    string
+    CAFile     string
+    Pins       []string
+    MinVersion uint16
+}
+
+/**
+ * PinMismatchError is returned when the arc-auth-server's certificate chain matches none of the configured pins
+ */
+type PinMismatchError struct {
+    ServerName string
+    Presented  []string
+}
+
+func (e *PinMismatchError) Error() string {
+    return fmt.Sprintf("arcauth: certificate of %s matches none of the pinned keys (presented %s)", e.ServerName, strings.Join(e.Presented, ", "))
+}
+
+/**
+ * SPKIPin returns the pin of cert's public key, "sha256/" followed by the base64 SHA-256 of its SubjectPublicKeyInfo
+ */
+func SPKIPin(cert *x509.Certificate) string {
+    sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+    return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+/**
+ * NewTLSConfig builds the *tls.Config for settings, it fails when a file cannot be loaded or a pin is malformed
+ */
+func NewTLSConfig(settings TLSSettings) (*tls.Config, error) {
+    config := &tls.Config{MinVersion: settings.MinVersion}
+    if config.MinVersion == 0 {
+        config.MinVersion = tls.VersionTLS12
+    }
+
+    if (settings.CertFile == "") != (settings.KeyFile == "") {
+        return nil, fmt.Errorf("arcauth: a client certificate needs both a cert file and a key file")
+    }
+    if settings.CertFile != "" {
+        certificate := &certificateReloader{certFile: settings.CertFile, keyFile: settings.KeyFile}
+        if _, err := certificate.get(); err != nil {
+            return nil, err
+        }
+        config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+            return certificate.get()
+        }
+    }
+
+    pins := map[string]bool{}
+    for _, pin := range settings.Pins {
+        digest, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+        if err != nil || len(digest) != sha256.Size {
+            return nil, fmt.Errorf("arcauth: pin %q is not a sha256/<base64> SPKI pin", pin)
+        }
+        pins["sha256/" + base64.StdEncoding.EncodeToString(digest)] = true
+    }
+
+    var authorities *authorityReloader
+    if settings.CAFile != "" {
+        authorities = &authorityReloader{file: settings.CAFile}
+        if _, err := authorities.get(); err != nil {
+            return nil, err
+        }
+        // the chain is verified in VerifyConnection against the current bundle so that it can be reloaded
+        config.InsecureSkipVerify = true
+    }
+
+    if authorities != nil || len(pins) > 0 {
+        config.VerifyConnection = func(state tls.ConnectionState) error {
+            chains := state.VerifiedChains
+            if authorities != nil {
+                var err error
+                if chains, err = authorities.verify(state); err != nil {
+                    return err
+                }
+            }
+            return checkPins(state.ServerName, pins, chains)
+        }
+    }
+    return config, nil
+}
+
+/**
+ * WithTLS secures the connection to the arc-auth-server according to settings
+ */
+func WithTLS(settings TLSSettings) Option {
+    return func(this *options) error {
+        config, err := NewTLSConfig(settings)
+        if err != nil {
+            return err
+        }
+        this.tlsConfig = config
+        return nil
+    }
+}
+
+func checkPins(serverName string, pins map[string]bool, chains [][]*x509.Certificate) error {
+    if len(pins) == 0 {
+        return nil
+    }
+    var presented []string
+    for _, chain := range chains {
+        for _, cert := range chain {
+            pin := SPKIPin(cert)
+            if pins[pin] {
+                return nil
+            }
+            presented = append(presented, pin)
+        }
+    }
+    return &PinMismatchError{ServerName: serverName, Presented: presented}
+}
+
+/**
+ * certificateReloader keeps a client certificate loaded from files, reloading it when either file changes
+ */
+type certificateReloader struct {
+    certFile    string
+    keyFile     string
+
+    mutex       sync.Mutex
+    certificate *tls.Certificate
+    modified    time.Time
+}
+
+func (this *certificateReloader) get() (*tls.Certificate, error) {
+    modified, err := latestModification(this.certFile, this.keyFile)
+    if err != nil {
+        return nil, fmt.Errorf("arcauth: cannot read client certificate: %v", err)
+    }
+
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    if this.certificate != nil && modified.Equal(this.modified) {
+        return this.certificate, nil
+    }
+    certificate, err := tls.LoadX509KeyPair(this.certFile, this.keyFile)
+    if err != nil {
+        if this.certificate != nil {
+            // a rotation may be half written, keep using the previous pair until both files are consistent
+            return this.certificate, nil
+        }
+        return nil, fmt.Errorf("arcauth: cannot load client certificate: %v", err)
+    }
+    this.certificate = &certificate
+    this.modified = modified
+    return this.certificate, nil
+}
+
+/**
+ * authorityReloader keeps a CA bundle loaded from a file, reloading it when the file changes
+ */
+type authorityReloader struct {
+    file     string
+
+    mutex    sync.Mutex
+    pool     *x509.CertPool
+    modified time.Time
+}
+
+func (this *authorityReloader) get() (*x509.CertPool, error) {
+    modified, err := latestModification(this.file)
+    if err != nil {
+        return nil, fmt.Errorf("arcauth: cannot read CA bundle: %v", err)
+    }
+
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    if this.pool != nil && modified.Equal(this.modified) {
+        return this.pool, nil
+    }
+    content, err := ioutil.ReadFile(this.file)
+    pool := x509.NewCertPool()
+    if err == nil && !pool.AppendCertsFromPEM(content) {
+        err = fmt.Errorf("no PEM certificate found")
+    }
+    if err != nil {
+        if this.pool != nil {
+            return this.pool, nil
+        }
+        return nil, fmt.Errorf("arcauth: cannot load CA bundle: %v", err)
+    }
+    this.pool = pool
+    this.modified = modified
+    return this.pool, nil
+}
+
+func (this *authorityReloader) verify(state tls.ConnectionState) ([][]*x509.Certificate, error) {
+    if len(state.PeerCertificates) == 0 {
+        return nil, fmt.Errorf("arcauth: the server presented no certificate")
+    }
+    if state.ServerName == "" {
+        // an empty name would make Verify skip the hostname check and accept any certificate the CA signed
+        return nil, fmt.Errorf("arcauth: no server name to verify the certificate against")
+    }
+    roots, err := this.get()
+    if err != nil {
+        return nil, err
+    }
+    intermediates := x509.NewCertPool()
+    for _, cert := range state.PeerCertificates[1:] {
+        intermediates.AddCert(cert)
+    }
+    return state.PeerCertificates[0].Verify(x509.VerifyOptions{
+        Roots:         roots,
+        Intermediates: intermediates,
+        DNSName:       state.ServerName,
+    })
+}
+
+/**
+ * dialTLS returns a DialTLSContext for transport that tells its VerifyConnection the host that was dialed
+ *
+ * Go does not send SNI to a server addressed by IP, so tls.ConnectionState.ServerName is empty for such a server and
+ * the CA bundle could not be checked against its address without it.
+ */
+func dialTLS(transport *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+    return func(ctx context.Context, network, addr string) (net.Conn, error) {
+        host, _, err := net.SplitHostPort(addr)
+        if err != nil {
+            return nil, err
+        }
+        dial := transport.DialContext
+        if dial == nil {
+            dial = (&net.Dialer{}).DialContext
+        }
+        conn, err := dial(ctx, network, addr)
+        if err != nil {
+            return nil, err
+        }
+
+        config := transport.TLSClientConfig.Clone()
+        if config.ServerName == "" {
+            config.ServerName = host
+        }
+        if verify := config.VerifyConnection; verify != nil {
+            config.VerifyConnection = func(state tls.ConnectionState) error {
+                if state.ServerName == "" {
+                    state.ServerName = config.ServerName
+                }
+                return verify(state)
+            }
+        }
+        if transport.TLSHandshakeTimeout > 0 {
+            var cancel context.CancelFunc
+            ctx, cancel = context.WithTimeout(ctx, transport.TLSHandshakeTimeout)
+            defer cancel()
+        }
+        tlsConn := tls.Client(conn, config)
+        if err := tlsConn.HandshakeContext(ctx); err != nil {
+            conn.Close()
+            return nil, err
+        }
+        return tlsConn, nil
+    }
+}
+
+func latestModification(files ...string) (time.Time, error) {
+    var latest time.Time
+    for _, file := range files {
+        info, err := os.Stat(file)
+        if err != nil {
+            return time.Time{}, err
+        }
+        if info.ModTime().After(latest) {
+            latest = info.ModTime()
+        }
+    }
+    return latest, nil
+}
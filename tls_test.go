@@ -0,0 +1,293 @@
+package arcauth
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "errors"
+    "io/ioutil"
+    "math/big"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+type testCertificate struct {
+    cert *x509.Certificate
+    key  *ecdsa.PrivateKey
+    tls  tls.Certificate
+}
+
+var serialNumber int64
+
+/**
+ * issue creates a certificate for commonName and 127.0.0.1 signed by parent, or self-signed CA when parent is nil
+ */
+func issue(t *testing.T, commonName string, parent *testCertificate) *testCertificate {
+    return issueFor(t, commonName, parent, net.ParseIP("127.0.0.1"))
+}
+
+/**
+ * issueFor is issue for other addresses than 127.0.0.1
+ */
+func issueFor(t *testing.T, commonName string, parent *testCertificate, addresses ...net.IP) *testCertificate {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    serialNumber++
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(serialNumber),
+        Subject:      pkix.Name{CommonName: commonName},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+        IPAddresses:  addresses,
+        DNSNames:     []string{commonName},
+    }
+    signerCert, signerKey := template, key
+    if parent == nil {
+        template.IsCA = true
+        template.BasicConstraintsValid = true
+    } else {
+        signerCert, signerKey = parent.cert, parent.key
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, _ := x509.ParseCertificate(der)
+    return &testCertificate{cert: cert, key: key, tls: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}}
+}
+
+func (this *testCertificate) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+    certFile = filepath.Join(dir, name + ".crt")
+    keyFile = filepath.Join(dir, name + ".key")
+    keyDER, _ := x509.MarshalECPrivateKey(this.key)
+    // write to temp files and rename, like secret managers do, so a reader never sees half a file
+    writeAtomically(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: this.cert.Raw}))
+    writeAtomically(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+    return certFile, keyFile
+}
+
+func writeAtomically(t *testing.T, path string, content []byte) {
+    if err := ioutil.WriteFile(path + ".tmp", content, 0600); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Rename(path + ".tmp", path); err != nil {
+        t.Fatal(err)
+    }
+}
+
+/**
+ * createMutualTLSServer serves the vaughant fixture over TLS with serverCert, requiring a client certificate signed
+ * by ca, and reports the common name of each client certificate on clients
+ */
+func createMutualTLSServer(ca, serverCert *testCertificate, clients chan string) *httptest.Server {
+    pool := x509.NewCertPool()
+    pool.AddCert(ca.cert)
+    testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case clients <- r.TLS.PeerCertificates[0].Subject.CommonName:
+        default:
+        }
+        w.Write([]byte(vaughantJSON))
+    }))
+    testServer.TLS = &tls.Config{
+        Certificates: []tls.Certificate{serverCert.tls},
+        ClientAuth:   tls.RequireAndVerifyClientCert,
+        ClientCAs:    pool,
+    }
+    testServer.StartTLS()
+    return testServer
+}
+
+func TestMutualTLSWithPrivateCA(t *testing.T) {
+    dir := t.TempDir()
+    ca := issue(t, "arc-auth test CA", nil)
+    caFile, _ := ca.writePEM(t, dir, "ca")
+    certFile, keyFile := issue(t, "stories-api", ca).writePEM(t, dir, "client")
+    clients := make(chan string, 1)
+    testServer := createMutualTLSServer(ca, issue(t, "arc-auth", ca), clients)
+    defer testServer.Close()
+
+    arcAuthClient, err := New(testServer.URL, "user", "pass", WithTLS(TLSSettings{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}))
+    assert.NoError(t, err)
+    result, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "vaughant", result.User.Username)
+    assert.Equal(t, "stories-api", <-clients)
+}
+
+func TestTLSRejectsServerOutsideCABundle(t *testing.T) {
+    dir := t.TempDir()
+    caFile, _ := issue(t, "some other CA", nil).writePEM(t, dir, "ca")
+    ca := issue(t, "arc-auth test CA", nil)
+    certFile, keyFile := issue(t, "stories-api", ca).writePEM(t, dir, "client")
+    testServer := createMutualTLSServer(ca, issue(t, "arc-auth", ca), make(chan string, 1))
+    defer testServer.Close()
+
+    arcAuthClient, _ := New(testServer.URL, "user", "pass", WithTLS(TLSSettings{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}))
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    var unknownAuthority x509.UnknownAuthorityError
+    assert.True(t, errors.As(err, &unknownAuthority), "expected an unknown authority error but got %v", err)
+}
+
+func TestTLSRejectsCertificateForAnotherHost(t *testing.T) {
+    dir := t.TempDir()
+    ca := issue(t, "arc-auth test CA", nil)
+    caFile, _ := ca.writePEM(t, dir, "ca")
+    certFile, keyFile := issue(t, "stories-api", ca).writePEM(t, dir, "client")
+    // signed by the trusted CA, but for another name and address than the 127.0.0.1 the client dials
+    testServer := createMutualTLSServer(ca, issueFor(t, "evil.example", ca, net.ParseIP("10.9.9.9")), make(chan string, 1))
+    defer testServer.Close()
+
+    arcAuthClient, _ := New(testServer.URL, "user", "pass", WithTLS(TLSSettings{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}))
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    var invalidHost x509.HostnameError
+    assert.True(t, errors.As(err, &invalidHost), "expected a hostname error but got %v", err)
+}
+
+func TestTLSConfigRejectsConnectionWithoutServerName(t *testing.T) {
+    dir := t.TempDir()
+    ca := issue(t, "arc-auth test CA", nil)
+    caFile, _ := ca.writePEM(t, dir, "ca")
+    config, _ := NewTLSConfig(TLSSettings{CAFile: caFile})
+
+    err := config.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{issue(t, "arc-auth", ca).cert}})
+
+    assert.Error(t, err, "a connection to an IP address carries no server name outside of New")
+}
+
+func TestTLSPinning(t *testing.T) {
+    dir := t.TempDir()
+    ca := issue(t, "arc-auth test CA", nil)
+    caFile, _ := ca.writePEM(t, dir, "ca")
+    certFile, keyFile := issue(t, "stories-api", ca).writePEM(t, dir, "client")
+    serverCert := issue(t, "arc-auth", ca)
+    testServer := createMutualTLSServer(ca, serverCert, make(chan string, 1))
+    defer testServer.Close()
+    settings := TLSSettings{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}
+
+    settings.Pins = []string{SPKIPin(serverCert.cert)}
+    arcAuthClient, _ := New(testServer.URL, "user", "pass", WithTLS(settings))
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err, "the server's own key is pinned")
+
+    settings.Pins = []string{SPKIPin(ca.cert)}
+    arcAuthClient, _ = New(testServer.URL, "user", "pass", WithTLS(settings))
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err, "the CA's key is pinned")
+
+    settings.Pins = []string{SPKIPin(issue(t, "elsewhere", nil).cert)}
+    arcAuthClient, _ = New(testServer.URL, "user", "pass", WithTLS(settings))
+    _, err = arcAuthClient.Authenticate("FakeDemoToken")
+    var mismatch *PinMismatchError
+    assert.True(t, errors.As(err, &mismatch), "expected a *PinMismatchError but got %v", err)
+    if mismatch != nil {
+        assert.Contains(t, mismatch.Presented, SPKIPin(serverCert.cert))
+    }
+}
+
+func TestTLSReloadsRotatedClientCertificate(t *testing.T) {
+    dir := t.TempDir()
+    ca := issue(t, "arc-auth test CA", nil)
+    caFile, _ := ca.writePEM(t, dir, "ca")
+    certFile, keyFile := issue(t, "stories-api-1", ca).writePEM(t, dir, "client")
+    clients := make(chan string, 1)
+    testServer := createMutualTLSServer(ca, issue(t, "arc-auth", ca), clients)
+    defer testServer.Close()
+
+    arcAuthClient, _ := New(testServer.URL, "user", "pass", WithTLS(TLSSettings{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}))
+    arcAuthClient.Authenticate("FakeDemoToken")
+    assert.Equal(t, "stories-api-1", <-clients)
+
+    issue(t, "stories-api-2", ca).writePEM(t, dir, "client")
+    future := time.Now().Add(time.Second)
+    os.Chtimes(certFile, future, future)
+    // new connections pick up the rotated certificate
+    arcAuthClient.HttpClient.CloseIdleConnections()
+    _, err := arcAuthClient.Authenticate("FakeDemoToken")
+
+    assert.NoError(t, err)
+    assert.Equal(t, "stories-api-2", <-clients)
+}
+
+func TestTLSReloadsRotatedCABundle(t *testing.T) {
+    dir := t.TempDir()
+    oldCA, newCA := issue(t, "arc-auth old CA", nil), issue(t, "arc-auth new CA", nil)
+    caFile, _ := oldCA.writePEM(t, dir, "ca")
+    certFile, keyFile := issue(t, "stories-api", oldCA).writePEM(t, dir, "client")
+    // both servers accept the same client certificate, only the CA of their own certificate differs
+    oldServer := createMutualTLSServer(oldCA, issue(t, "arc-auth", oldCA), make(chan string, 1))
+    defer oldServer.Close()
+    newServer := createMutualTLSServer(oldCA, issue(t, "arc-auth", newCA), make(chan string, 1))
+    defer newServer.Close()
+    settings := TLSSettings{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}
+    oldClient, _ := New(oldServer.URL, "user", "pass", WithTLS(settings))
+    newClient, _ := New(newServer.URL, "user", "pass", WithTLS(settings))
+    rotate := func(content []byte, age time.Duration) {
+        writeAtomically(t, caFile, content)
+        future := time.Now().Add(age)
+        os.Chtimes(caFile, future, future)
+        oldClient.HttpClient.CloseIdleConnections()
+        newClient.HttpClient.CloseIdleConnections()
+    }
+
+    _, err := newClient.Authenticate("FakeDemoToken")
+    var unknownAuthority x509.UnknownAuthorityError
+    assert.True(t, errors.As(err, &unknownAuthority), "the new CA is not trusted yet but got %v", err)
+
+    rotate(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: newCA.cert.Raw}), time.Second)
+    _, err = newClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err, "the rotated CA bundle is picked up")
+    _, err = oldClient.Authenticate("FakeDemoToken")
+    assert.True(t, errors.As(err, &unknownAuthority), "the old CA is no longer trusted but got %v", err)
+
+    rotate([]byte("not a certificate"), 2 * time.Second)
+    _, err = newClient.Authenticate("FakeDemoToken")
+    assert.NoError(t, err, "a broken rewrite keeps the last CA bundle that loaded")
+    _, err = oldClient.Authenticate("FakeDemoToken")
+    assert.True(t, errors.As(err, &unknownAuthority), "a broken rewrite does not fall back to the old CA but got %v", err)
+}
+
+func TestNewTLSConfigValidation(t *testing.T) {
+    _, err := NewTLSConfig(TLSSettings{CertFile: "client.crt"})
+    assert.Error(t, err, "a cert file needs a key file")
+
+    _, err = NewTLSConfig(TLSSettings{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+    assert.Error(t, err)
+
+    _, err = NewTLSConfig(TLSSettings{Pins: []string{"sha256/not-base64"}})
+    assert.Error(t, err)
+
+    config, err := NewTLSConfig(TLSSettings{})
+    assert.NoError(t, err)
+    assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+}
+
+func TestConfigTLSSettings(t *testing.T) {
+    config := &Config{Server: "https://a.example.com", User: "u", Pass: "p", TLSMinVersion: "1.0"}
+    _, err := config.NewClient()
+    assert.Equal(t, "ARC_AUTH_TLS_MIN_VERSION", err.(*ConfigError).Setting)
+
+    config.TLSMinVersion = "1.3"
+    arcAuthClient, err := config.NewClient()
+    assert.NoError(t, err)
+    transport := arcAuthClient.HttpClient.Transport.(*http.Transport)
+    assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+}